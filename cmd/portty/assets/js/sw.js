@@ -2,9 +2,9 @@
 // CONSTANTS AND CONFIGURATION
 // ============================================================================
 
-const CACHE_NAME = 'portty-v0.2';
-const STATIC_CACHE = 'portty-static-v0.2';
-const DYNAMIC_CACHE = 'portty-dynamic-v0.2';
+const CACHE_NAME = 'portty-v0.5';
+const STATIC_CACHE = 'portty-static-v0.5';
+const DYNAMIC_CACHE = 'portty-dynamic-v0.5';
 
 const STATIC_ASSETS = [
     '/',
@@ -16,7 +16,6 @@ const STATIC_ASSETS = [
     'https://cdn.jsdelivr.net/npm/@xterm/xterm@5.5.0/css/xterm.css',
     'https://cdn.jsdelivr.net/npm/@xterm/xterm@5.5.0/lib/xterm.js',
     'https://cdn.jsdelivr.net/npm/@xterm/addon-fit@0.10.0/lib/addon-fit.js',
-    'https://cdn.jsdelivr.net/npm/@xterm/addon-attach@0.11.0/lib/addon-attach.js',
     'https://fonts.googleapis.com/css2?family=JetBrains+Mono:wght@400;700&display=swap'
 ];
 
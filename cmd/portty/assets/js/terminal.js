@@ -5,6 +5,43 @@
 const MAX_RECONNECT_ATTEMPTS = 5;
 const RECONNECT_DELAY = 1000;
 const KEEP_ALIVE_INTERVAL = 30000;
+const INITIAL_FIT_TIMEOUT = 1000;
+
+// Framed protocol, see docs/PROTOCOL.md
+const PROTOCOL = 'portty.v1';
+const PROTOCOL_VERSION = 1;
+const CLIENT_FEATURES = ['clipboard', 'resume', 'flow-control', 'compression', 'latency', 'file-transfer'];
+const OP_DATA = 0x00;
+const OP_RESIZE = 0x01;
+const OP_PING = 0x02;
+const OP_PONG = 0x03;
+const OP_CONTROL = 0x04;
+const OP_OUTPUT = 0x05;
+const OP_ACK = 0x06;
+const OP_UPLOAD = 0x07;
+
+// Output is acknowledged once xterm.js has processed it, in batches
+const ACK_THRESHOLD = 16384;
+const ACK_DELAY = 50;
+
+// Round trip times from this many milliseconds on are shown as slow
+const SLOW_RTT_MS = 250;
+
+// Uploads stay at most this far ahead of what the server confirmed
+const UPLOAD_WINDOW = 1024 * 1024;
+const UPLOAD_NOTICE_DURATION = 5000;
+
+const textEncoder = new TextEncoder();
+const textDecoder = new TextDecoder();
+
+const serverMessageHandlers = {};
+
+// Where to resume the session after a dropped connection: the token from the
+// server's hello and the sequence following the last output byte received
+const resumeState = { token: null, sequence: 0 };
+
+// Output bytes processed but not yet acknowledged to the server
+const ackState = { bytes: 0, timer: null };
 
 // ============================================================================
 // UTILITY FUNCTIONS
@@ -69,11 +106,88 @@ async function fetchFontConfig() {
     }
 }
 
+function decodeBase64(data) {
+    const binary = atob(data || '');
+    const bytes = new Uint8Array(binary.length);
+    for (let i = 0; i < binary.length; i++) {
+        bytes[i] = binary.charCodeAt(i);
+    }
+    return bytes;
+}
+
+// clearCachedAssets drops the assets the service worker cached so the next
+// reload fetches the frontend that matches the server
+function clearCachedAssets() {
+    if ('caches' in window) {
+        caches.keys()
+            .then((keys) => Promise.all(keys.map((key) => caches.delete(key))))
+            .catch((error) => console.warn('[PorTTY] Failed to clear cached assets:', error));
+    }
+    if ('serviceWorker' in navigator) {
+        navigator.serviceWorker.getRegistration()
+            .then((registration) => registration && registration.update())
+            .catch((error) => console.warn('[PorTTY] Failed to update service worker:', error));
+    }
+}
+
+function registerServerMessageHandler(type, handler) {
+    serverMessageHandlers[type] = handler;
+}
+
+function encodeFrame(opcode, payload) {
+    const frame = new Uint8Array(payload.length + 1);
+    frame[0] = opcode;
+    frame.set(payload, 1);
+    return frame;
+}
+
+function sendFrame(opcode, payload = new Uint8Array(0), socket = window.porttySocket) {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+        socket.send(encodeFrame(opcode, payload));
+    }
+}
+
+function sendControlMessage(message) {
+    sendFrame(OP_CONTROL, textEncoder.encode(JSON.stringify(message)));
+}
+
+function encodeResize(dimensions) {
+    const hasPixels = dimensions.width > 0 && dimensions.height > 0;
+    const payload = new Uint8Array(hasPixels ? 8 : 4);
+    const view = new DataView(payload.buffer);
+    view.setUint16(0, dimensions.cols);
+    view.setUint16(2, dimensions.rows);
+    if (hasPixels) {
+        view.setUint16(4, dimensions.width);
+        view.setUint16(6, dimensions.height);
+    }
+    return payload;
+}
+
+function encodeUpload(id, data) {
+    const payload = new Uint8Array(data.length + 4);
+    new DataView(payload.buffer).setUint32(0, id);
+    payload.set(data, 4);
+    return payload;
+}
+
+function describeExit(message) {
+    let text = message.signal
+        ? `Process terminated by signal ${message.signal_name || message.signal}`
+        : `Process exited with code ${message.code}`;
+    
+    if (message.action === 'keep') {
+        text += ' - press Enter to restart';
+    } else if (message.action === 'respawn') {
+        text += ` - restarting in ${Math.round((message.respawn_delay_ms || 0) / 1000)}s`;
+    }
+    return text;
+}
+
 function validateDependencies() {
     const requiredAddons = [
         { name: 'Terminal', check: () => typeof Terminal !== 'undefined' },
         { name: 'FitAddon', check: () => typeof window.FitAddon !== 'undefined' && typeof window.FitAddon.FitAddon !== 'undefined' },
-        { name: 'AttachAddon', check: () => typeof window.AttachAddon !== 'undefined' && typeof window.AttachAddon.AttachAddon !== 'undefined' },
         { name: 'WebglAddon', check: () => typeof window.WebglAddon !== 'undefined' && typeof window.WebglAddon.WebglAddon !== 'undefined' },
         { name: 'SearchAddon', check: () => typeof window.SearchAddon !== 'undefined' && typeof window.SearchAddon.SearchAddon !== 'undefined' },
         { name: 'Unicode11Addon', check: () => typeof window.Unicode11Addon !== 'undefined' && typeof window.Unicode11Addon.Unicode11Addon !== 'undefined' },
@@ -102,6 +216,7 @@ class ConnectionStatusManager {
     constructor() {
         this.statusIndicator = document.getElementById('status-indicator');
         this.statusText = document.getElementById('status-text');
+        this.statusLatency = document.getElementById('status-latency');
         this.connectionStatus = document.getElementById('connection-status');
         this.isLocalhost = isRunningOnLocalhost();
         this.initialize();
@@ -161,6 +276,25 @@ class ConnectionStatusManager {
             
             this.statusText.textContent = statusMessages[status] || status;
         }
+        if (status !== 'connected') {
+            this.updateLatency(null);
+        }
+    }
+    
+    // updateLatency shows the round trip time the server measured, or clears
+    // it when message is null
+    updateLatency(message) {
+        if (!this.statusLatency) {
+            return;
+        }
+        if (!message) {
+            this.statusLatency.textContent = '';
+            this.statusLatency.classList.remove('slow');
+            return;
+        }
+        
+        this.statusLatency.textContent = `${Math.round(message.rtt_ms)} ms ±${Math.round(message.jitter_ms)}`;
+        this.statusLatency.classList.toggle('slow', message.rtt_ms >= SLOW_RTT_MS);
     }
     
     ensureVisibilityFallback() {
@@ -344,6 +478,589 @@ class SearchManager {
     }
 }
 
+/**
+ * Renders tmux control-mode windows as tabs and panes as positioned terminals.
+ */
+class BroadcastManager {
+    constructor(term) {
+        this.term = term;
+        this.sessions = [];
+        this.status = null;
+        this.state = null;
+        
+        const initial = new URLSearchParams(window.location.search).get('broadcast');
+        if (initial) {
+            this.sessions = initial.split(',').map((name) => name.trim()).filter(Boolean);
+        }
+        
+        this.createIndicator();
+    }
+    
+    createIndicator() {
+        this.indicator = document.createElement('div');
+        this.indicator.className = 'broadcast-indicator hidden';
+        this.indicator.innerHTML = `
+            <span class="broadcast-text"></span>
+            <button class="broadcast-action"></button>
+        `;
+        document.body.appendChild(this.indicator);
+        
+        this.text = this.indicator.querySelector('.broadcast-text');
+        this.action = this.indicator.querySelector('.broadcast-action');
+        this.action.addEventListener('click', () => {
+            if (this.sessions.length > 0) {
+                this.select([]);
+            } else if (this.state) {
+                sendControlMessage({ type: 'broadcast-opt-out', opt_out: !this.state.opt_out });
+            }
+            this.term.focus();
+        });
+    }
+    
+    select(sessions) {
+        this.sessions = sessions;
+        if (sessions.length === 0) {
+            this.status = null;
+        }
+        sendControlMessage({ type: 'broadcast', sessions });
+        this.render();
+    }
+    
+    promptSelection() {
+        const answer = prompt('Broadcast input to sessions (comma separated, * for all, empty to stop)',
+            this.sessions.join(', '));
+        if (answer !== null) {
+            this.select(answer.split(',').map((name) => name.trim()).filter(Boolean));
+        }
+    }
+    
+    // The server forgets the selection when the connection drops
+    restore() {
+        if (this.sessions.length > 0) {
+            sendControlMessage({ type: 'broadcast', sessions: this.sessions });
+        }
+    }
+    
+    handleStatus(message) {
+        this.status = message;
+        this.render();
+    }
+    
+    handleState(message) {
+        this.state = message;
+        this.render();
+    }
+    
+    render() {
+        const sending = this.sessions.length > 0 && this.status;
+        const receiving = this.state && (this.state.sources.length > 0 || this.state.opt_out);
+        
+        this.indicator.classList.toggle('hidden', !sending && !receiving);
+        this.indicator.classList.toggle('failed', Boolean(sending && this.status.failures && this.status.failures.length > 0));
+        
+        if (sending) {
+            const parts = [`Broadcasting to ${this.status.targets.length ? this.status.targets.join(', ') : 'no sessions'}`];
+            if (this.status.skipped && this.status.skipped.length > 0) {
+                parts.push(`skipped: ${this.status.skipped.join(', ')}`);
+            }
+            if (this.status.failures && this.status.failures.length > 0) {
+                parts.push(`failed: ${this.status.failures.map((failure) => `${failure.session} (${failure.error})`).join(', ')}`);
+            }
+            this.text.textContent = parts.join(' · ');
+            this.action.textContent = 'Stop';
+        } else if (receiving) {
+            this.text.textContent = this.state.opt_out
+                ? 'Ignoring broadcast input'
+                : `Receiving input from ${this.state.sources.join(', ')}`;
+            this.action.textContent = this.state.opt_out ? 'Opt in' : 'Opt out';
+        }
+    }
+}
+
+class UploadManager {
+    constructor(term) {
+        this.term = term;
+        this.queue = [];
+        this.current = null;
+        this.nextId = 1;
+        this.hideTimer = null;
+        
+        this.createIndicator();
+        this.setupDropTarget();
+    }
+    
+    createIndicator() {
+        this.indicator = document.createElement('div');
+        this.indicator.className = 'upload-indicator hidden';
+        this.indicator.innerHTML = `
+            <span class="upload-text"></span>
+            <button class="upload-action"></button>
+        `;
+        document.body.appendChild(this.indicator);
+        
+        this.text = this.indicator.querySelector('.upload-text');
+        this.action = this.indicator.querySelector('.upload-action');
+        this.action.addEventListener('click', () => {
+            if (this.current) {
+                this.cancel();
+            } else {
+                this.indicator.classList.add('hidden');
+            }
+            this.term.focus();
+        });
+    }
+    
+    setupDropTarget() {
+        const container = document.getElementById('terminal-container');
+        const carriesFiles = (event) => event.dataTransfer && Array.from(event.dataTransfer.types).includes('Files');
+        
+        container.addEventListener('dragover', (event) => {
+            if (!carriesFiles(event)) {
+                return;
+            }
+            event.preventDefault();
+            event.dataTransfer.dropEffect = 'copy';
+            container.classList.add('drop-target');
+        });
+        container.addEventListener('dragleave', (event) => {
+            if (!container.contains(event.relatedTarget)) {
+                container.classList.remove('drop-target');
+            }
+        });
+        container.addEventListener('drop', (event) => {
+            container.classList.remove('drop-target');
+            if (!carriesFiles(event)) {
+                return;
+            }
+            event.preventDefault();
+            this.enqueue(Array.from(event.dataTransfer.files));
+        });
+    }
+    
+    enqueue(files) {
+        if (!window.porttyFeatures || !window.porttyFeatures.has('file-transfer')) {
+            this.notify('File upload is turned off on this server', true);
+            return;
+        }
+        this.queue.push(...files);
+        if (!this.current) {
+            this.next();
+        }
+    }
+    
+    next() {
+        const file = this.queue.shift();
+        if (!file) {
+            return;
+        }
+        this.current = { id: this.nextId++, file, sent: 0, received: 0, socket: window.porttySocket, cancelled: false, waiting: null };
+        sendControlMessage({ type: 'upload', id: this.current.id, name: file.name, size: file.size });
+        this.render();
+    }
+    
+    // send streams the file in chunks, pausing while too much of it is
+    // waiting for the server to confirm
+    async send(upload, chunkSize) {
+        while (upload.sent < upload.file.size && !upload.cancelled) {
+            if (upload.sent - upload.received >= UPLOAD_WINDOW) {
+                await new Promise((resolve) => { upload.waiting = resolve; });
+                continue;
+            }
+            const end = Math.min(upload.sent + chunkSize, upload.file.size);
+            const data = new Uint8Array(await upload.file.slice(upload.sent, end).arrayBuffer());
+            if (upload.cancelled) {
+                return;
+            }
+            sendFrame(OP_UPLOAD, encodeUpload(upload.id, data), upload.socket);
+            upload.sent = end;
+        }
+    }
+    
+    wake(upload) {
+        if (upload.waiting) {
+            const resolve = upload.waiting;
+            upload.waiting = null;
+            resolve();
+        }
+    }
+    
+    handleStatus(message) {
+        const upload = this.current;
+        if (!upload || message.id !== upload.id) {
+            return;
+        }
+        
+        switch (message.state) {
+            case 'started':
+                this.send(upload, message.chunk_size).catch((error) => {
+                    console.error('[PorTTY] Failed to read file for upload:', error);
+                    this.cancel();
+                });
+                break;
+            case 'progress':
+                upload.received = message.received;
+                this.wake(upload);
+                this.render();
+                break;
+            case 'done':
+                this.finish(`Uploaded ${message.path}`, false);
+                break;
+            case 'failed':
+                this.finish(`Upload of ${upload.file.name} failed: ${message.error}`, true);
+                break;
+            case 'cancelled':
+                this.finish(`Upload of ${upload.file.name} cancelled`, false);
+                break;
+        }
+    }
+    
+    finish(text, failed) {
+        const upload = this.current;
+        upload.cancelled = true;
+        this.wake(upload);
+        this.current = null;
+        this.notify(text, failed);
+        this.next();
+    }
+    
+    cancel() {
+        if (!this.current) {
+            return;
+        }
+        this.queue = [];
+        this.current.cancelled = true;
+        this.wake(this.current);
+        sendControlMessage({ type: 'upload-cancel', id: this.current.id });
+    }
+    
+    // The server abandons unfinished uploads when the connection drops
+    disconnected() {
+        if (this.current) {
+            this.queue = [];
+            this.finish(`Upload of ${this.current.file.name} failed: connection lost`, true);
+        }
+    }
+    
+    render() {
+        const upload = this.current;
+        const percent = upload.file.size > 0 ? Math.floor(upload.received * 100 / upload.file.size) : 0;
+        const queued = this.queue.length > 0 ? ` · ${this.queue.length} more queued` : '';
+        
+        clearTimeout(this.hideTimer);
+        this.indicator.classList.remove('hidden', 'failed');
+        this.text.textContent = `Uploading ${upload.file.name} · ${percent}%${queued}`;
+        this.action.textContent = 'Cancel';
+    }
+    
+    notify(text, failed) {
+        clearTimeout(this.hideTimer);
+        this.indicator.classList.remove('hidden');
+        this.indicator.classList.toggle('failed', failed);
+        this.text.textContent = text;
+        this.action.textContent = 'Dismiss';
+        this.hideTimer = setTimeout(() => this.indicator.classList.add('hidden'), UPLOAD_NOTICE_DURATION);
+    }
+}
+
+class TmuxControlView {
+    constructor(term) {
+        this.term = term;
+        this.windows = new Map();
+        this.panes = new Map();
+        this.pendingOutput = new Map();
+        this.activeWindow = null;
+        this.activePane = null;
+        this.createView();
+    }
+    
+    createView() {
+        document.body.classList.add('tmux-mode');
+        
+        this.view = document.createElement('section');
+        this.view.className = 'tmux-view';
+        this.view.innerHTML = `
+            <nav class="tmux-tabs">
+                <div class="tmux-tab-list"></div>
+                <button class="tmux-action" data-action="split-horizontal" title="Split pane left/right">⇆</button>
+                <button class="tmux-action" data-action="split-vertical" title="Split pane top/bottom">⇅</button>
+                <button class="tmux-action" data-action="new-window" title="New window">+</button>
+            </nav>
+            <div class="tmux-windows"></div>
+        `;
+        document.body.appendChild(this.view);
+        
+        this.tabList = this.view.querySelector('.tmux-tab-list');
+        this.windowArea = this.view.querySelector('.tmux-windows');
+        
+        this.view.querySelectorAll('.tmux-action').forEach((button) => {
+            button.addEventListener('click', () => this.handleAction(button.dataset.action));
+        });
+        
+        if (window.porttyManualResize) {
+            requestAnimationFrame(() => window.porttyManualResize(true));
+        }
+    }
+    
+    handleAction(action) {
+        switch (action) {
+            case 'new-window':
+                this.sendCommand('new-window');
+                break;
+            case 'split-horizontal':
+                this.sendCommand('split-window', { target: this.activePane, direction: 'horizontal' });
+                break;
+            case 'split-vertical':
+                this.sendCommand('split-window', { target: this.activePane, direction: 'vertical' });
+                break;
+        }
+    }
+    
+    sendCommand(command, options = {}) {
+        const message = { type: 'tmux-command', command };
+        if (options.target) {
+            message.target = options.target;
+        }
+        if (options.direction) {
+            message.direction = options.direction;
+        }
+        if (options.name) {
+            message.name = options.name;
+        }
+        sendControlMessage(message);
+    }
+    
+    handleEvent(event) {
+        switch (event.event) {
+            case 'window-add':
+                this.addWindow(event.window, event.name);
+                if (event.active) {
+                    this.showWindow(event.window);
+                }
+                break;
+            case 'window-close':
+                this.removeWindow(event.window);
+                break;
+            case 'window-renamed':
+                this.renameWindow(event.window, event.name);
+                break;
+            case 'layout-change':
+                this.applyLayout(event.window, event.layout);
+                break;
+            case 'window-pane-changed':
+                this.activatePane(event.pane);
+                break;
+            case 'session-window-changed':
+                this.showWindow(event.window);
+                break;
+            case 'output':
+                this.writeOutput(event.pane, decodeBase64(event.data));
+                break;
+            case 'error':
+                console.warn('[PorTTY] tmux error:', event.message);
+                break;
+            case 'exit':
+                this.term.write(`\r\n\x1b[33mtmux control mode exited${event.message ? `: ${event.message}` : ''}\x1b[0m\r\n`);
+                break;
+        }
+    }
+    
+    // reset forgets every window and pane before the server describes them
+    // again on a new connection
+    reset() {
+        this.activeWindow = null;
+        this.activePane = null;
+        [...this.windows.keys()].forEach((windowId) => this.removeWindow(windowId));
+        this.pendingOutput.clear();
+    }
+    
+    addWindow(windowId, name) {
+        if (this.windows.has(windowId)) {
+            if (name) {
+                this.renameWindow(windowId, name);
+            }
+            return;
+        }
+        
+        const tab = document.createElement('button');
+        tab.className = 'tmux-tab';
+        tab.textContent = name || windowId;
+        tab.addEventListener('click', () => {
+            this.showWindow(windowId);
+            this.sendCommand('select-window', { target: windowId });
+        });
+        tab.addEventListener('dblclick', () => {
+            const newName = prompt('Rename window', tab.textContent);
+            if (newName) {
+                this.sendCommand('rename-window', { target: windowId, name: newName });
+            }
+        });
+        this.tabList.appendChild(tab);
+        
+        const element = document.createElement('div');
+        element.className = 'tmux-window hidden';
+        this.windowArea.appendChild(element);
+        
+        this.windows.set(windowId, { id: windowId, tab, element, panes: new Set() });
+        
+        if (!this.activeWindow) {
+            this.showWindow(windowId);
+        }
+    }
+    
+    removeWindow(windowId) {
+        const tmuxWindow = this.windows.get(windowId);
+        if (!tmuxWindow) {
+            return;
+        }
+        
+        tmuxWindow.panes.forEach((paneId) => this.removePane(paneId));
+        tmuxWindow.tab.remove();
+        tmuxWindow.element.remove();
+        this.windows.delete(windowId);
+        
+        if (this.activeWindow === windowId) {
+            this.activeWindow = null;
+            const next = this.windows.keys().next();
+            if (!next.done) {
+                this.showWindow(next.value);
+            }
+        }
+    }
+    
+    renameWindow(windowId, name) {
+        const tmuxWindow = this.windows.get(windowId);
+        if (tmuxWindow && name) {
+            tmuxWindow.tab.textContent = name;
+        }
+    }
+    
+    showWindow(windowId) {
+        if (!this.windows.has(windowId)) {
+            this.addWindow(windowId);
+        }
+        
+        this.activeWindow = windowId;
+        this.windows.forEach((tmuxWindow, id) => {
+            const active = id === windowId;
+            tmuxWindow.tab.classList.toggle('active', active);
+            tmuxWindow.element.classList.toggle('hidden', !active);
+        });
+    }
+    
+    applyLayout(windowId, layout) {
+        if (!layout) {
+            return;
+        }
+        
+        this.addWindow(windowId);
+        const tmuxWindow = this.windows.get(windowId);
+        const leaves = [];
+        const collectLeaves = (node) => {
+            if (node.pane) {
+                leaves.push(node);
+            }
+            (node.children || []).forEach(collectLeaves);
+        };
+        collectLeaves(layout);
+        
+        const visiblePanes = new Set(leaves.map((leaf) => leaf.pane));
+        tmuxWindow.panes.forEach((paneId) => {
+            if (!visiblePanes.has(paneId)) {
+                this.removePane(paneId);
+            }
+        });
+        
+        leaves.forEach((leaf) => {
+            const pane = this.panes.get(leaf.pane) || this.createPane(leaf.pane, tmuxWindow);
+            pane.element.style.left = `${(leaf.x / layout.width) * 100}%`;
+            pane.element.style.top = `${(leaf.y / layout.height) * 100}%`;
+            pane.element.style.width = `${(leaf.width / layout.width) * 100}%`;
+            pane.element.style.height = `${(leaf.height / layout.height) * 100}%`;
+            pane.term.resize(Math.max(leaf.width, 1), Math.max(leaf.height, 1));
+        });
+    }
+    
+    createPane(paneId, tmuxWindow) {
+        const element = document.createElement('div');
+        element.className = 'tmux-pane';
+        tmuxWindow.element.appendChild(element);
+        
+        const paneTerm = new Terminal({
+            cursorBlink: true,
+            fontFamily: this.term.options.fontFamily,
+            fontSize: this.term.options.fontSize,
+            fontWeight: this.term.options.fontWeight,
+            fontWeightBold: this.term.options.fontWeightBold,
+            theme: this.term.options.theme,
+            scrollback: this.term.options.scrollback,
+            allowProposedApi: true
+        });
+        paneTerm.open(element);
+        paneTerm.onData((data) => {
+            sendControlMessage({ type: 'tmux-input', pane: paneId, data });
+        });
+        element.addEventListener('mousedown', () => {
+            if (this.activePane !== paneId) {
+                this.sendCommand('select-pane', { target: paneId });
+            }
+        });
+        
+        const pane = { id: paneId, element, term: paneTerm, windowId: tmuxWindow.id };
+        this.panes.set(paneId, pane);
+        tmuxWindow.panes.add(paneId);
+        
+        const pending = this.pendingOutput.get(paneId);
+        if (pending) {
+            pending.forEach((chunk) => paneTerm.write(chunk));
+            this.pendingOutput.delete(paneId);
+        }
+        
+        return pane;
+    }
+    
+    removePane(paneId) {
+        const pane = this.panes.get(paneId);
+        if (!pane) {
+            return;
+        }
+        
+        pane.term.dispose();
+        pane.element.remove();
+        this.panes.delete(paneId);
+        this.pendingOutput.delete(paneId);
+        
+        const tmuxWindow = this.windows.get(pane.windowId);
+        if (tmuxWindow) {
+            tmuxWindow.panes.delete(paneId);
+        }
+    }
+    
+    activatePane(paneId) {
+        this.activePane = paneId;
+        this.panes.forEach((pane, id) => {
+            pane.element.classList.toggle('active', id === paneId);
+        });
+        
+        const pane = this.panes.get(paneId);
+        if (pane && pane.windowId === this.activeWindow) {
+            pane.term.focus();
+        }
+    }
+    
+    writeOutput(paneId, data) {
+        const pane = this.panes.get(paneId);
+        if (pane) {
+            pane.term.write(data);
+            return;
+        }
+        
+        if (!this.pendingOutput.has(paneId)) {
+            this.pendingOutput.set(paneId, []);
+        }
+        this.pendingOutput.get(paneId).push(data);
+    }
+}
+
 // ============================================================================
 // MAIN INITIALIZATION LOGIC
 // ============================================================================
@@ -434,6 +1151,7 @@ async function initializePorTTY() {
                 fitAddon.fit();
                 term.focus();
                 sendResize(term);
+                startConnection();
             } catch (error) {
                 console.error('[PorTTY] Error during initial fit:', error);
                 setTimeout(() => {
@@ -443,6 +1161,7 @@ async function initializePorTTY() {
                     } catch (retryError) {
                         console.error('[PorTTY] Retry fit also failed:', retryError);
                     }
+                    startConnection();
                 }, 100);
             }
         } else {
@@ -476,9 +1195,107 @@ async function initializePorTTY() {
     window.porttyFontManager = fontManager;
     window.porttyFontSizeManager = fontSizeManager;
     window.porttySearchManager = searchManager;
+    window.porttySendSignal = sendSignal;
+    
+    const broadcastManager = new BroadcastManager(term);
+    window.porttyBroadcastManager = broadcastManager;
+    window.porttyBroadcast = (sessions) => broadcastManager.select(sessions);
+    registerServerMessageHandler('broadcast-status', (message) => broadcastManager.handleStatus(message));
+    registerServerMessageHandler('broadcast-state', (message) => broadcastManager.handleState(message));
+    
+    const uploadManager = new UploadManager(term);
+    window.porttyUploadManager = uploadManager;
+    registerServerMessageHandler('upload-status', (message) => uploadManager.handleStatus(message));
+    
+    registerServerMessageHandler('tmux', (message) => {
+        if (!window.porttyTmuxView) {
+            window.porttyTmuxView = new TmuxControlView(term);
+        }
+        window.porttyTmuxView.handleEvent(message);
+    });
+    
+    registerServerMessageHandler('hello', (message) => {
+        if (message.version !== PROTOCOL_VERSION) {
+            connectionManager.updateStatus('failed');
+            term.write(`\r\n\x1b[31mThis page speaks protocol version ${PROTOCOL_VERSION}, but the server (${message.server_version}) speaks version ${message.version}. Reload the page to update it.\x1b[0m\r\n`);
+            clearCachedAssets();
+            window.porttySocket.close(1000, 'protocol version mismatch');
+            return;
+        }
+        
+        window.porttyServer = message;
+        window.porttyFeatures = new Set(message.features || []);
+        
+        // Signals can't reach the programs inside a multiplexer
+        const signalMenu = document.getElementById('signal-menu');
+        if (signalMenu) {
+            signalMenu.classList.toggle('hidden', Boolean(message.terminal && message.terminal.multiplexer));
+        }
+        ackState.bytes = 0;
+        
+        // Without a resume the server replays the whole scrollback, which
+        // would otherwise be appended to what is already on screen
+        if (!message.resumed) {
+            term.reset();
+            resumeState.sequence = 0;
+            if (window.porttyTmuxView) {
+                window.porttyTmuxView.reset();
+            }
+        }
+        resumeState.token = window.porttyFeatures.has('resume') ? message.resume_token : null;
+        console.info(`[PorTTY] Connected to server ${message.server_version}, features: ${[...window.porttyFeatures].join(', ') || 'none'}`);
+    });
+    
+    registerServerMessageHandler('latency', (message) => connectionManager.updateLatency(message));
+    
+    registerServerMessageHandler('session', (message) => {
+        window.porttySessionName = message.name;
+        document.title = window.porttyProcessTitle
+            ? `${window.porttyProcessTitle} - ${message.name}`
+            : `PorTTY - ${message.name}`;
+        
+        // Keep the session in the URL so reconnects and reloads rejoin it
+        const params = new URLSearchParams(window.location.search);
+        if (params.get('session') !== message.name) {
+            params.set('session', message.name);
+            window.history.replaceState(null, '', `${window.location.pathname}?${params}`);
+        }
+    });
+    
+    registerServerMessageHandler('process', (message) => {
+        window.porttyProcessTitle = message.title;
+        document.title = window.porttySessionName
+            ? `${message.title} - ${window.porttySessionName}`
+            : message.title;
+    });
+    
+    registerServerMessageHandler('job', (message) => {
+        // The server replays the whole output on every connect, so start
+        // from a clean screen rather than appending to an earlier replay
+        term.reset();
+        document.title = `${message.command.join(' ')} - job ${message.id}`;
+        window.porttyJob = message;
+    });
+    
+    registerServerMessageHandler('exited', (message) => {
+        const color = message.code === 0 ? '90' : '31';
+        term.write(`\r\n\x1b[${color}m[${describeExit(message)}]\x1b[0m\r\n`);
+    });
+    
+    // Connect only once the terminal has been fitted so the server can start
+    // the shell at the real size instead of the 80x24 default
+    let connectionStarted = false;
+    const startConnection = () => {
+        if (connectionStarted) {
+            return;
+        }
+        connectionStarted = true;
+        setupWebSocketConnection(term, fitAddon, connectionManager, socket, reconnectAttempts);
+    };
+    setTimeout(startConnection, INITIAL_FIT_TIMEOUT);
     
-    setupWebSocketConnection(term, fitAddon, connectionManager, socket, reconnectAttempts);
     setupReactiveResize(term, fitAddon);
+    setupSignalMenu(term);
     setupKeyboardShortcuts(fontSizeManager, searchManager, term);
 }
 
@@ -486,42 +1303,183 @@ async function initializePorTTY() {
 // EVENT LISTENERS AND HANDLERS
 // ============================================================================
 
+function handleServerMessage(term, text) {
+    let message;
+    try {
+        message = JSON.parse(text);
+    } catch (error) {
+        console.warn('[PorTTY] Ignoring malformed control message:', error);
+        return;
+    }
+    
+    const handler = message && serverMessageHandlers[message.type];
+    if (handler) {
+        handler(message);
+    }
+}
+
+// writeOutput writes sequenced output, skipping anything already written
+// before a resume
+function writeOutput(term, payload, socket) {
+    if (payload.length < 8) {
+        return;
+    }
+    const view = new DataView(payload.buffer, payload.byteOffset, payload.byteLength);
+    const sequence = Number(view.getBigUint64(0));
+    const data = payload.subarray(8);
+    const end = sequence + data.length;
+    
+    if (end <= resumeState.sequence) {
+        acknowledgeOutput(data.length, socket);
+        return;
+    }
+    term.write(data.subarray(Math.max(0, resumeState.sequence - sequence)), () => acknowledgeOutput(data.length, socket));
+    resumeState.sequence = end;
+}
+
+// acknowledgeOutput returns credit for output to the server, which stops
+// reading from the terminal while too much is unacknowledged
+function acknowledgeOutput(bytes, socket) {
+    if (!window.porttyFeatures || !window.porttyFeatures.has('flow-control')) {
+        return;
+    }
+    
+    ackState.bytes += bytes;
+    if (ackState.bytes >= ACK_THRESHOLD) {
+        flushAck(socket);
+    } else if (!ackState.timer) {
+        ackState.timer = setTimeout(() => flushAck(socket), ACK_DELAY);
+    }
+}
+
+function flushAck(socket) {
+    clearTimeout(ackState.timer);
+    ackState.timer = null;
+    if (ackState.bytes > 0) {
+        const payload = new Uint8Array(4);
+        new DataView(payload.buffer).setUint32(0, ackState.bytes);
+        sendFrame(OP_ACK, payload, socket);
+        ackState.bytes = 0;
+    }
+}
+
+function attachSocket(term, socket) {
+    socket.binaryType = 'arraybuffer';
+    
+    socket.addEventListener('message', (event) => {
+        if (typeof event.data === 'string') {
+            return;
+        }
+        
+        const frame = new Uint8Array(event.data);
+        if (frame.length === 0) {
+            return;
+        }
+        const payload = frame.subarray(1);
+        
+        switch (frame[0]) {
+            case OP_DATA:
+                term.write(payload, () => acknowledgeOutput(payload.length, socket));
+                break;
+            case OP_OUTPUT:
+                writeOutput(term, payload, socket);
+                break;
+            case OP_CONTROL:
+                handleServerMessage(term, textDecoder.decode(payload));
+                break;
+            case OP_PING:
+                sendFrame(OP_PONG, payload, socket);
+                break;
+        }
+    });
+    
+    const dataListener = term.onData((data) => {
+        sendFrame(OP_DATA, textEncoder.encode(data), socket);
+    });
+    
+    const binaryListener = term.onBinary((data) => {
+        const bytes = new Uint8Array(data.length);
+        for (let i = 0; i < data.length; i++) {
+            bytes[i] = data.charCodeAt(i) & 255;
+        }
+        sendFrame(OP_DATA, bytes, socket);
+    });
+    
+    socket.addEventListener('close', () => {
+        dataListener.dispose();
+        binaryListener.dispose();
+    });
+}
+
 function setupWebSocketConnection(term, fitAddon, connectionManager, socket, reconnectAttempts) {
     function connectWebSocket() {
         connectionManager.updateStatus('connecting');
         
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        const wsUrl = `${protocol}//${window.location.host}/ws`;
+        const params = new URLSearchParams(window.location.search);
+        const dimensions = terminalDimensions(term);
+        for (const [key, value] of Object.entries(dimensions)) {
+            params.set(key, value);
+        }
+        const wsUrl = `${protocol}//${window.location.host}/ws?${params}`;
         
-        socket = new WebSocket(wsUrl);
+        socket = new WebSocket(wsUrl, PROTOCOL);
         window.porttySocket = socket;
-        const attachAddon = new window.AttachAddon.AttachAddon(socket);
-        term.loadAddon(attachAddon);
+        attachSocket(term, socket);
         
         socket.addEventListener('open', () => {
             connectionManager.updateStatus('connected');
             reconnectAttempts = 0;
+            sendHello(term);
             sendResize(term);
+            if (window.porttyBroadcastManager) {
+                window.porttyBroadcastManager.restore();
+            }
         });
         
         socket.addEventListener('error', (event) => {
             console.error('WebSocket error:', event);
             connectionManager.updateStatus('error');
-            term.write('\r\n\x1b[31mWebSocket connection error\x1b[0m\r\n');
+            // Resumed sessions continue exactly where they stopped, so keep
+            // connection notices off the screen while they can resume
+            if (!resumeState.token) {
+                term.write('\r\n\x1b[31mWebSocket connection error\x1b[0m\r\n');
+            }
         });
         
         socket.addEventListener('close', (event) => {
             connectionManager.updateStatus('disconnected');
+            if (window.porttyUploadManager) {
+                window.porttyUploadManager.disconnected();
+            }
             
-            if (event.code !== 1000 && reconnectAttempts < MAX_RECONNECT_ATTEMPTS) {
+            if (event.code === 1000 && event.reason === 'session closed') {
+                term.write('\r\n\x1b[33mSession closed. Press Enter to start a new session.\x1b[0m\r\n');
+                const restartListener = term.onData((data) => {
+                    if (data.includes('\r')) {
+                        restartListener.dispose();
+                        connectWebSocket();
+                    }
+                });
+            } else if (event.code === 1008) {
+                connectionManager.updateStatus('failed');
+                term.write(`\r\n\x1b[31m${event.reason || 'Session rejected by server'}\x1b[0m\r\n`);
+                if (event.reason && event.reason.includes('Reload the page')) {
+                    clearCachedAssets();
+                }
+            } else if (event.code !== 1000 && reconnectAttempts < MAX_RECONNECT_ATTEMPTS) {
                 reconnectAttempts++;
                 const delay = RECONNECT_DELAY * Math.pow(1.5, reconnectAttempts - 1);
                 
                 connectionManager.updateStatus('reconnecting');
-                term.write(`\r\n\x1b[33mConnection closed. Reconnecting in ${Math.round(delay/1000)} seconds...\x1b[0m\r\n`);
+                if (!resumeState.token) {
+                    term.write(`\r\n\x1b[33mConnection closed. Reconnecting in ${Math.round(delay/1000)} seconds...\x1b[0m\r\n`);
+                }
                 
                 setTimeout(() => {
-                    term.write('\r\n\x1b[33mAttempting to reconnect...\x1b[0m\r\n');
+                    if (!resumeState.token) {
+                        term.write('\r\n\x1b[33mAttempting to reconnect...\x1b[0m\r\n');
+                    }
                     connectWebSocket();
                 }, delay);
             } else if (reconnectAttempts >= MAX_RECONNECT_ATTEMPTS) {
@@ -532,7 +1490,7 @@ function setupWebSocketConnection(term, fitAddon, connectionManager, socket, rec
         
         const keepAliveInterval = setInterval(() => {
             if (socket && socket.readyState === WebSocket.OPEN) {
-                socket.send(JSON.stringify({ type: 'keepalive' }));
+                sendFrame(OP_PING, new Uint8Array(0), socket);
             } else {
                 clearInterval(keepAliveInterval);
             }
@@ -542,24 +1500,57 @@ function setupWebSocketConnection(term, fitAddon, connectionManager, socket, rec
     connectWebSocket();
 }
 
+function terminalDimensions(term) {
+    const dimensions = { cols: term.cols, rows: term.rows };
+    const screen = term.element && term.element.querySelector('.xterm-screen');
+    if (screen && screen.clientWidth > 0 && screen.clientHeight > 0) {
+        dimensions.width = screen.clientWidth;
+        dimensions.height = screen.clientHeight;
+    }
+    return dimensions;
+}
+
+function sendHello(term) {
+    const hello = {
+        type: 'hello',
+        version: PROTOCOL_VERSION,
+        features: CLIENT_FEATURES,
+        client: 'portty-web',
+        dimensions: terminalDimensions(term)
+    };
+    if (resumeState.token) {
+        hello.resume = { token: resumeState.token, sequence: resumeState.sequence };
+    }
+    sendControlMessage(hello);
+}
+
 function sendResize(term) {
-    const socket = window.porttySocket;
-    if (socket && socket.readyState === WebSocket.OPEN) {
-        const resizeMessage = JSON.stringify({
-            type: 'resize',
-            dimensions: {
-                cols: term.cols,
-                rows: term.rows
-            }
-        });
-        socket.send(resizeMessage);
+    sendFrame(OP_RESIZE, encodeResize(terminalDimensions(term)));
+}
+
+function sendSignal(signal, target = 'foreground') {
+    sendControlMessage({ type: 'signal', signal, target });
+}
+
+function setupSignalMenu(term) {
+    const menu = document.getElementById('signal-menu');
+    if (!menu) {
+        return;
     }
+    
+    menu.addEventListener('change', () => {
+        if (menu.value) {
+            sendSignal(menu.value);
+            menu.value = '';
+        }
+        term.focus();
+    });
 }
 
 function setupReactiveResize(term, fitAddon) {
     let lastDimensions = { width: 0, height: 0 };
     
-    const performResize = () => {
+    const performResize = (force = false) => {
         const container = document.getElementById('terminal-container');
         if (!container) {
             return;
@@ -571,7 +1562,8 @@ function setupReactiveResize(term, fitAddon) {
             return;
         }
         
-        if (Math.abs(containerRect.width - lastDimensions.width) < 5 &&
+        if (!force &&
+            Math.abs(containerRect.width - lastDimensions.width) < 5 &&
             Math.abs(containerRect.height - lastDimensions.height) < 5) {
             return;
         }
@@ -586,7 +1578,7 @@ function setupReactiveResize(term, fitAddon) {
         }
     };
     
-    window.addEventListener('resize', performResize);
+    window.addEventListener('resize', () => performResize());
     window.addEventListener('beforeunload', () => {
         if (window.porttySocket && window.porttySocket.readyState === WebSocket.OPEN) {
             window.porttySocket.close(1000, 'Page unloaded');
@@ -627,6 +1619,15 @@ function setupKeyboardShortcuts(fontSizeManager, searchManager, term) {
             return;
         }
         
+        if (e.ctrlKey && e.shiftKey && (e.key === 'b' || e.key === 'B')) {
+            e.preventDefault();
+            e.stopPropagation();
+            if (window.porttyBroadcastManager) {
+                window.porttyBroadcastManager.promptSelection();
+            }
+            return;
+        }
+        
         if ((e.ctrlKey && e.key === 'r') || e.key === 'F5') {
             if (window.porttySocket && window.porttySocket.readyState !== WebSocket.OPEN) {
                 e.preventDefault();
@@ -0,0 +1,424 @@
+package attach
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+	"github.com/PiTZE/PorTTY/internal/session"
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+const (
+	DefaultDetachKeys = "ctrl-]"
+	keepaliveInterval = 30 * time.Second
+)
+
+// ErrDetached is returned when the user leaves the session with the detach keys
+var ErrDetached = errors.New("detached")
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// Options configures a native terminal attachment
+type Options struct {
+	URL        string
+	Session    string
+	DetachKeys string
+}
+
+type client struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+	detachKeys []byte
+	// flowControl is set when the server expects output to be acknowledged
+	flowControl bool
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// ParseDetachKeys converts a sequence such as "ctrl-p,ctrl-q" into raw bytes
+func ParseDetachKeys(spec string) ([]byte, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("detach key sequence cannot be empty")
+	}
+
+	var keys []byte
+	for _, key := range strings.Split(spec, ",") {
+		key = strings.TrimSpace(key)
+		switch {
+		case strings.HasPrefix(strings.ToLower(key), "ctrl-") && len(key) == 6:
+			c := key[5]
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			if c < '@' || c > '_' {
+				return nil, fmt.Errorf("invalid control key %q", key)
+			}
+			keys = append(keys, c&0x1f)
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		default:
+			return nil, fmt.Errorf("invalid detach key %q (use a single character or ctrl-<key>)", key)
+		}
+	}
+
+	return keys, nil
+}
+
+// BuildWebSocketURL normalizes a server address into its WebSocket endpoint
+func BuildWebSocketURL(rawURL, sessionName string) (*url.URL, error) {
+	if rawURL == "" {
+		rawURL = cfg.Server.DefaultAddress
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL %q: %w", rawURL, err)
+	}
+
+	switch target.Scheme {
+	case "http", "ws":
+		target.Scheme = "ws"
+	case "https", "wss":
+		target.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", target.Scheme)
+	}
+
+	if !strings.HasSuffix(target.Path, "/ws") {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/ws"
+	}
+
+	query := target.Query()
+	if sessionName != "" {
+		query.Set("session", sessionName)
+	}
+	target.RawQuery = query.Encode()
+
+	return target, nil
+}
+
+func buildRequestHeader(target *url.URL) (http.Header, error) {
+	header := http.Header{}
+
+	originScheme := "http"
+	if target.Scheme == "wss" {
+		originScheme = "https"
+	}
+	header.Set("Origin", originScheme+"://"+target.Host)
+
+	if target.User == nil {
+		return header, nil
+	}
+
+	username := target.User.Username()
+	password, hasPassword := target.User.Password()
+	if !hasPassword {
+		var err error
+		password, err = promptPassword(fmt.Sprintf("Password for %s@%s: ", username, target.Host))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	header.Set("Authorization", "Basic "+credentials)
+	target.User = nil
+
+	return header, nil
+}
+
+func promptPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	restore, err := disableEcho(os.Stdin)
+	if err == nil {
+		defer restore()
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// Run attaches the local terminal to a PorTTY session until it ends or the
+// user detaches
+func Run(ctx context.Context, options Options) error {
+	detachKeys, err := ParseDetachKeys(options.DetachKeys)
+	if err != nil {
+		return err
+	}
+
+	target, err := BuildWebSocketURL(options.URL, options.Session)
+	if err != nil {
+		return err
+	}
+
+	header, err := buildRequestHeader(target)
+	if err != nil {
+		return err
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  cfg.WebSocket.WriteWait,
+		ReadBufferSize:    cfg.WebSocket.ReadBufferSize,
+		WriteBufferSize:   cfg.WebSocket.WriteBufferSize,
+		Subprotocols:      []string{protocol.Subprotocol},
+		EnableCompression: true,
+	}
+
+	conn, response, err := dialer.Dial(target.String(), header)
+	if err != nil {
+		if response != nil {
+			return fmt.Errorf("failed to connect to %s: %s", target.Redacted(), response.Status)
+		}
+		return fmt.Errorf("failed to connect to %s: %w", target.Redacted(), err)
+	}
+	defer conn.Close()
+
+	if conn.Subprotocol() != protocol.Subprotocol {
+		return fmt.Errorf("server at %s does not support protocol version %d; upgrade it to attach",
+			target.Redacted(), protocol.Version)
+	}
+
+	c := &client{conn: conn, detachKeys: detachKeys}
+
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to put terminal in raw mode: %w", err)
+	}
+	defer restore()
+
+	fmt.Fprintf(os.Stderr, "[attached to %s, press %s to detach]\r\n", target.Redacted(), options.DetachKeys)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.sendHello()
+	c.sendResize()
+	stopResize := watchResize(c.sendResize)
+	defer stopResize()
+
+	errChan := make(chan error, 2)
+	go func() { errChan <- c.readOutput() }()
+	go func() { errChan <- c.forwardInput(ctx) }()
+	go c.keepalive(ctx)
+
+	select {
+	case err = <-errChan:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	closeReason := "client exited"
+	if err == ErrDetached {
+		closeReason = "client detached"
+	}
+	c.writeMutex.Lock()
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, closeReason),
+		time.Now().Add(time.Second))
+	c.writeMutex.Unlock()
+
+	return err
+}
+
+func (c *client) send(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+	return c.conn.WriteMessage(websocket.BinaryMessage, protocol.EncodeFrame(opcode, payload))
+}
+
+// sendHello opens the protocol exchange. Output is acknowledged once it has
+// been written to the local terminal.
+func (c *client) sendHello() {
+	hello := protocol.HelloMessage{
+		Type:     protocol.TypeHello,
+		Version:  protocol.Version,
+		Features: []string{protocol.FeatureFlowControl, protocol.FeatureCompression},
+		Client:   "portty-attach",
+	}
+	if size, err := pty.GetsizeFull(os.Stdin); err == nil {
+		hello.Dimensions = &protocol.Dimensions{Cols: int(size.Cols), Rows: int(size.Rows)}
+	}
+
+	if message, err := protocol.Encode(hello); err == nil {
+		c.send(protocol.OpControl, message)
+	}
+}
+
+func (c *client) sendResize() {
+	size, err := pty.GetsizeFull(os.Stdin)
+	if err != nil {
+		return
+	}
+
+	c.send(protocol.OpResize, protocol.EncodeResize(protocol.Dimensions{Cols: int(size.Cols), Rows: int(size.Rows)}))
+}
+
+func (c *client) keepalive(ctx context.Context) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.send(protocol.OpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *client) readOutput() error {
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				// The session ending or this client detaching is a normal
+				// end; anything else the server explains is reported
+				if closeErr.Code == websocket.CloseNormalClosure {
+					switch closeErr.Text {
+					case "", session.ReasonSessionClosed, session.ReasonDetached:
+						return nil
+					}
+				}
+				if closeErr.Text != "" {
+					return fmt.Errorf("connection closed by server: %s", closeErr.Text)
+				}
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) || websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+				return nil
+			}
+			return fmt.Errorf("connection lost: %w", err)
+		}
+
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+		opcode, payload, err := protocol.DecodeFrame(data)
+		if err != nil {
+			continue
+		}
+
+		switch opcode {
+		case protocol.OpData:
+			if _, err := os.Stdout.Write(payload); err != nil {
+				return fmt.Errorf("failed to write output: %w", err)
+			}
+			if c.flowControl {
+				c.send(protocol.OpAck, protocol.EncodeAck(uint32(len(payload))))
+			}
+		case protocol.OpPing:
+			c.send(protocol.OpPong, payload)
+		case protocol.OpControl:
+			if msgType, ok := protocol.DecodeType(payload); !ok || msgType != protocol.TypeHello {
+				continue
+			}
+			var hello protocol.HelloMessage
+			if err := json.Unmarshal(payload, &hello); err != nil {
+				continue
+			}
+			if hello.Version != protocol.Version {
+				return fmt.Errorf("server %s speaks protocol version %d, this client speaks version %d",
+					hello.ServerVersion, hello.Version, protocol.Version)
+			}
+			c.flowControl = protocol.HasFeature(hello.Features, protocol.FeatureFlowControl)
+		}
+	}
+}
+
+func (c *client) forwardInput(ctx context.Context) error {
+	buf := make([]byte, 4096)
+	matched := 0
+
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+
+		out := make([]byte, 0, n+matched)
+		for _, b := range buf[:n] {
+			if b == c.detachKeys[matched] {
+				matched++
+				if matched == len(c.detachKeys) {
+					if len(out) > 0 {
+						c.send(protocol.OpData, out)
+					}
+					return ErrDetached
+				}
+				continue
+			}
+
+			if matched > 0 {
+				out = append(out, c.detachKeys[:matched]...)
+				matched = 0
+				if b == c.detachKeys[0] {
+					matched = 1
+					continue
+				}
+			}
+			out = append(out, b)
+		}
+
+		if len(out) == 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if err := c.send(protocol.OpData, out); err != nil {
+			return fmt.Errorf("failed to send input: %w", err)
+		}
+	}
+}
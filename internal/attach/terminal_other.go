@@ -0,0 +1,22 @@
+//go:build !darwin && !freebsd && !linux && !netbsd && !openbsd
+
+package attach
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("raw terminal mode is not supported on this platform")
+
+func makeRaw(file *os.File) (func(), error) {
+	return nil, errUnsupported
+}
+
+func disableEcho(file *os.File) (func(), error) {
+	return nil, errUnsupported
+}
+
+func watchResize(onResize func()) func() {
+	return func() {}
+}
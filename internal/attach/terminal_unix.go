@@ -0,0 +1,86 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+
+package attach
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	termios := &syscall.Termios{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return nil, errno
+	}
+	return termios, nil
+}
+
+func setTermios(fd uintptr, termios *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func updateTermios(file *os.File, update func(*syscall.Termios)) (func(), error) {
+	fd := file.Fd()
+
+	original, err := getTermios(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a terminal: %w", file.Name(), err)
+	}
+
+	modified := *original
+	update(&modified)
+	if err := setTermios(fd, &modified); err != nil {
+		return nil, err
+	}
+
+	return func() { setTermios(fd, original) }, nil
+}
+
+// makeRaw switches the terminal to raw mode, equivalent to cfmakeraw(3)
+func makeRaw(file *os.File) (func(), error) {
+	return updateTermios(file, func(t *syscall.Termios) {
+		t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+			syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+		t.Oflag &^= syscall.OPOST
+		t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		t.Cflag &^= syscall.CSIZE | syscall.PARENB
+		t.Cflag |= syscall.CS8
+		t.Cc[syscall.VMIN] = 1
+		t.Cc[syscall.VTIME] = 0
+	})
+}
+
+func disableEcho(file *os.File) (func(), error) {
+	return updateTermios(file, func(t *syscall.Termios) {
+		t.Lflag &^= syscall.ECHO
+		t.Lflag |= syscall.ICANON | syscall.ISIG
+	})
+}
+
+func watchResize(onResize func()) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGWINCH)
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				onResize()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
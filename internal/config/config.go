@@ -5,10 +5,14 @@ package config
 // ============================================================================
 
 import (
+	"compress/flate"
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,31 +24,50 @@ import (
 // ============================================================================
 
 type Config struct {
-	Server    ServerConfig    `toml:"server"`
-	Terminal  TerminalConfig  `toml:"terminal"`
-	WebSocket WebSocketConfig `toml:"websocket"`
-	UI        UIConfig        `toml:"ui"`
+	Server    ServerConfig             `toml:"server"`
+	Terminal  TerminalConfig           `toml:"terminal"`
+	WebSocket WebSocketConfig          `toml:"websocket"`
+	Jobs      JobsConfig               `toml:"jobs"`
+	Uploads   UploadsConfig            `toml:"uploads"`
+	UI        UIConfig                 `toml:"ui"`
+	Profiles  map[string]ProfileConfig `toml:"profiles,omitempty"`
 }
 
 type ServerConfig struct {
-	DefaultAddress      string        `toml:"default_address"`
-	SessionName         string        `toml:"session_name"`
-	PidFileName         string        `toml:"pid_file_name"`
-	Version             string        `toml:"version"`
-	PidFilePermissions  os.FileMode   `toml:"pid_file_permissions"`
-	ShutdownTimeout     time.Duration `toml:"shutdown_timeout"`
-	FallbackTempDir     string        `toml:"fallback_temp_dir"`
-	PTYOperationTimeout time.Duration `toml:"pty_operation_timeout"`
-	TmuxCleanupTimeout  time.Duration `toml:"tmux_cleanup_timeout"`
-	UseTmux             bool          `toml:"use_tmux"`
+	DefaultAddress           string        `toml:"default_address"`
+	SessionName              string        `toml:"session_name"`
+	PidFileName              string        `toml:"pid_file_name"`
+	Version                  string        `toml:"version"`
+	PidFilePermissions       os.FileMode   `toml:"pid_file_permissions"`
+	ShutdownTimeout          time.Duration `toml:"shutdown_timeout"`
+	FallbackTempDir          string        `toml:"fallback_temp_dir"`
+	PTYOperationTimeout      time.Duration `toml:"pty_operation_timeout"`
+	HangupGracePeriod        time.Duration `toml:"hangup_grace_period"`
+	TerminateGracePeriod     time.Duration `toml:"terminate_grace_period"`
+	TmuxCleanupTimeout       time.Duration `toml:"tmux_cleanup_timeout"`
+	Multiplexer              string        `toml:"multiplexer"`
+	TmuxControlMode          bool          `toml:"tmux_control_mode"`
+	ControlSocketName        string        `toml:"control_socket_name"`
+	ControlSocketPermissions os.FileMode   `toml:"control_socket_permissions"`
+	RestoreSessions          bool          `toml:"restore_sessions"`
+	SessionStateDir          string        `toml:"session_state_dir"`
+	SessionSaveInterval      time.Duration `toml:"session_save_interval"`
+	RestoreEnv               []string      `toml:"restore_env"`
 }
 
 type TerminalConfig struct {
-	DefaultRows  int    `toml:"default_rows"`
-	DefaultCols  int    `toml:"default_cols"`
-	DefaultTerm  string `toml:"default_term"`
-	DefaultColor string `toml:"default_color"`
-	DefaultShell string `toml:"default_shell"`
+	DefaultRows         int           `toml:"default_rows"`
+	DefaultCols         int           `toml:"default_cols"`
+	DefaultTerm         string        `toml:"default_term"`
+	DefaultColor        string        `toml:"default_color"`
+	DefaultShell        string        `toml:"default_shell"`
+	DefaultProfile      string        `toml:"default_profile"`
+	LoginShell          bool          `toml:"login_shell"`
+	WorkingDirectory    string        `toml:"working_directory"`
+	ExitAction          string        `toml:"exit_action"`
+	RespawnDelay        time.Duration `toml:"respawn_delay"`
+	ScrollbackBytes     int           `toml:"scrollback_bytes"`
+	ProcessPollInterval time.Duration `toml:"process_poll_interval"`
 }
 
 type WebSocketConfig struct {
@@ -53,9 +76,67 @@ type WebSocketConfig struct {
 	PingPeriod           time.Duration `toml:"ping_period"`
 	MaxMessageSize       int64         `toml:"max_message_size"`
 	MessageChannelBuffer int           `toml:"message_channel_buffer"`
+	MaxQueuedInput       int           `toml:"max_queued_input"`
 	ReadBufferSize       int           `toml:"read_buffer_size"`
 	WriteBufferSize      int           `toml:"write_buffer_size"`
 	ErrorRetryDelay      time.Duration `toml:"error_retry_delay"`
+	InitialSizeTimeout   time.Duration `toml:"initial_size_timeout"`
+	ResumeTimeout        time.Duration `toml:"resume_timeout"`
+	FlowHighWater        int           `toml:"flow_high_water"`
+	FlowLowWater         int           `toml:"flow_low_water"`
+	AckTimeout           time.Duration `toml:"ack_timeout"`
+	CoalesceDelay        time.Duration `toml:"coalesce_delay"`
+	CoalesceBytes        int           `toml:"coalesce_bytes"`
+	EchoFlushBytes       int           `toml:"echo_flush_bytes"`
+	Compression          bool          `toml:"compression"`
+	CompressionLevel     int           `toml:"compression_level"`
+	CompressionThreshold int           `toml:"compression_threshold"`
+	LatencyInterval      time.Duration `toml:"latency_interval"`
+	CarryEscapeSequences bool          `toml:"carry_escape_sequences"`
+	CarryTimeout         time.Duration `toml:"carry_timeout"`
+	ReplaceInvalidUTF8   bool          `toml:"replace_invalid_utf8"`
+	LegacyProtocol       bool          `toml:"legacy_protocol"`
+	TTYDCompat           bool          `toml:"ttyd_compat"`
+}
+
+// JobsConfig controls commands run in the background with `portty jobs run`
+type JobsConfig struct {
+	OutputLimit int           `toml:"output_limit"`
+	MaxRetained int           `toml:"max_retained"`
+	KillTimeout time.Duration `toml:"kill_timeout"`
+}
+
+// UploadsConfig controls files dropped into the browser, which are written
+// to the working directory of the session's foreground process
+type UploadsConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	MaxSize  int64  `toml:"max_size"`
+	Conflict string `toml:"conflict"`
+}
+
+// ProfileConfig describes a named program that can be launched in place of
+// the default shell, selected in the browser with /?profile=<name>
+type ProfileConfig struct {
+	Command     string            `toml:"command"`
+	Args        []string          `toml:"args,omitempty"`
+	Cwd         string            `toml:"cwd,omitempty"`
+	Env         map[string]string `toml:"env,omitempty"`
+	Term        string            `toml:"term,omitempty"`
+	ColorTerm   string            `toml:"colorterm,omitempty"`
+	Multiplexer string            `toml:"multiplexer,omitempty"`
+	Description string            `toml:"description,omitempty"`
+	Params      []ProfileParam    `toml:"params,omitempty"`
+}
+
+// ProfileParam declares a positional argument that may be supplied from the
+// URL with &arg=value. The whole value must match Pattern.
+type ProfileParam struct {
+	Name     string `toml:"name" json:"name"`
+	Pattern  string `toml:"pattern" json:"pattern"`
+	Required bool   `toml:"required,omitempty" json:"required"`
+
+	// matcher is Pattern compiled by Load, anchored at both ends
+	matcher *regexp.Regexp
 }
 
 type UIConfig struct {
@@ -124,6 +205,53 @@ func getShellFromPasswd(username string) string {
 	return ""
 }
 
+// ProfileNames returns the configured profile names in sorted order
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Matches reports whether value is allowed for the parameter. Nothing is
+// allowed if the pattern was never compiled.
+func (p ProfileParam) Matches(value string) bool {
+	return p.matcher != nil && p.matcher.MatchString(value)
+}
+
+// compileParams compiles the argument patterns of every profile, so they
+// are checked when the config is loaded rather than on each session
+func (c *Config) compileParams() error {
+	for _, name := range c.ProfileNames() {
+		params := c.Profiles[name].Params
+		for i, param := range params {
+			// The bare pattern is compiled first so errors quote what the
+			// user wrote; wrapping a valid pattern keeps it valid
+			if _, err := regexp.Compile(param.Pattern); err != nil {
+				label := param.Name
+				if label == "" {
+					label = fmt.Sprintf("argument %d", i+1)
+				}
+				return fmt.Errorf("%w: profile %q, %s: %v", ErrInvalidPattern, name, label, err)
+			}
+			params[i].matcher = regexp.MustCompile("^(?:" + param.Pattern + ")$")
+		}
+	}
+	return nil
+}
+
+// Environment returns the profile's extra variables as sorted KEY=value pairs
+func (p ProfileConfig) Environment() []string {
+	env := make([]string, 0, len(p.Env))
+	for key, value := range p.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
 func getSystemMonospaceFont() string {
 	return "monospace"
 }
@@ -158,23 +286,38 @@ func getConfigPath() (string, error) {
 func newDefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			DefaultAddress:      "localhost:7314",
-			SessionName:         "PorTTY",
-			PidFileName:         ".portty.pid",
-			Version:             "v0.2",
-			PidFilePermissions:  0644,
-			ShutdownTimeout:     5 * time.Second,
-			FallbackTempDir:     "/tmp",
-			PTYOperationTimeout: 3 * time.Second,
-			TmuxCleanupTimeout:  2 * time.Second,
-			UseTmux:             false,
+			DefaultAddress:           "localhost:7314",
+			SessionName:              "PorTTY",
+			PidFileName:              ".portty.pid",
+			Version:                  "v0.2",
+			PidFilePermissions:       0644,
+			ShutdownTimeout:          5 * time.Second,
+			FallbackTempDir:          "/tmp",
+			PTYOperationTimeout:      3 * time.Second,
+			HangupGracePeriod:        2 * time.Second,
+			TerminateGracePeriod:     3 * time.Second,
+			TmuxCleanupTimeout:       2 * time.Second,
+			Multiplexer:              "none",
+			TmuxControlMode:          false,
+			ControlSocketName:        ".portty.sock",
+			ControlSocketPermissions: 0600,
+			RestoreSessions:          false,
+			SessionStateDir:          "sessions",
+			SessionSaveInterval:      30 * time.Second,
+			RestoreEnv:               []string{"LANG", "LANGUAGE", "LC_*", "TZ", "EDITOR", "VISUAL", "PAGER"},
 		},
 		Terminal: TerminalConfig{
-			DefaultRows:  24,
-			DefaultCols:  80,
-			DefaultTerm:  "xterm-256color",
-			DefaultColor: "truecolor",
-			DefaultShell: getDefaultShell(),
+			DefaultRows:         24,
+			DefaultCols:         80,
+			DefaultTerm:         "xterm-256color",
+			DefaultColor:        "truecolor",
+			DefaultShell:        getDefaultShell(),
+			LoginShell:          false,
+			WorkingDirectory:    "",
+			ExitAction:          "close",
+			RespawnDelay:        2 * time.Second,
+			ScrollbackBytes:     64 * 1024,
+			ProcessPollInterval: time.Second,
 		},
 		WebSocket: WebSocketConfig{
 			WriteWait:            10 * time.Second,
@@ -182,9 +325,37 @@ func newDefaultConfig() *Config {
 			PingPeriod:           (60 * time.Second * 9) / 10,
 			MaxMessageSize:       16384,
 			MessageChannelBuffer: 100,
+			MaxQueuedInput:       4 * 1024 * 1024,
 			ReadBufferSize:       4096,
 			WriteBufferSize:      4096,
 			ErrorRetryDelay:      50 * time.Millisecond,
+			InitialSizeTimeout:   time.Second,
+			ResumeTimeout:        30 * time.Second,
+			FlowHighWater:        256 * 1024,
+			FlowLowWater:         64 * 1024,
+			AckTimeout:           15 * time.Second,
+			CoalesceDelay:        5 * time.Millisecond,
+			CoalesceBytes:        32 * 1024,
+			EchoFlushBytes:       256,
+			Compression:          true,
+			CompressionLevel:     flate.BestSpeed,
+			CompressionThreshold: 512,
+			LatencyInterval:      5 * time.Second,
+			CarryEscapeSequences: true,
+			CarryTimeout:         20 * time.Millisecond,
+			ReplaceInvalidUTF8:   false,
+			LegacyProtocol:       false,
+			TTYDCompat:           false,
+		},
+		Jobs: JobsConfig{
+			OutputLimit: 16 * 1024 * 1024,
+			MaxRetained: 50,
+			KillTimeout: 5 * time.Second,
+		},
+		Uploads: UploadsConfig{
+			Enabled:  false,
+			MaxSize:  100 * 1024 * 1024,
+			Conflict: "rename",
 		},
 		UI: UIConfig{
 			FontFamily: getSystemMonospaceFont(),
@@ -211,12 +382,29 @@ func Load() (*Config, error) {
 		return config, nil
 	}
 
-	var config Config
-	if _, err := toml.DecodeFile(configPath, &config); err != nil {
+	config := newDefaultConfig()
+	metadata, err := toml.DecodeFile(configPath, config)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode config file: %w", err)
 	}
 
-	return &config, nil
+	// Configs written before multiplexers were pluggable only had use_tmux
+	if !metadata.IsDefined("server", "multiplexer") && metadata.IsDefined("server", "use_tmux") {
+		var legacy struct {
+			Server struct {
+				UseTmux bool `toml:"use_tmux"`
+			} `toml:"server"`
+		}
+		if _, err := toml.DecodeFile(configPath, &legacy); err == nil && legacy.Server.UseTmux {
+			config.Server.Multiplexer = "tmux"
+		}
+	}
+
+	if err := config.compileParams(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
 }
 
 func (c *Config) Save() error {
@@ -243,12 +431,19 @@ func (c *Config) Save() error {
 // GLOBAL CONFIGURATION INSTANCE
 // ============================================================================
 
+// ErrInvalidPattern is returned by Load when a profile parameter's pattern
+// is not a valid regular expression
+var ErrInvalidPattern = errors.New("invalid profile argument pattern")
+
 var Default *Config
 
+// LoadError is why the config file could not be loaded, in which case
+// Default holds the built-in defaults
+var LoadError error
+
 func init() {
-	var err error
-	Default, err = Load()
-	if err != nil {
+	Default, LoadError = Load()
+	if LoadError != nil {
 		Default = newDefaultConfig()
 	}
 }
@@ -0,0 +1,510 @@
+package control
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+	"github.com/PiTZE/PorTTY/internal/jobs"
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/metrics"
+	"github.com/PiTZE/PorTTY/internal/session"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+const clientTimeout = 10 * time.Second
+
+// probeTimeout bounds the check whether another server owns the socket
+const probeTimeout = time.Second
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// Server exposes session management over a local Unix socket
+type Server struct {
+	sessions   *session.Manager
+	jobs       *jobs.Manager
+	socketPath string
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+// Client talks to a running server through its control socket
+type Client struct {
+	socketPath string
+	httpClient *http.Client
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+type signalRequest struct {
+	Signal string `json:"signal"`
+	Target string `json:"target,omitempty"`
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.ControlLogger.Error("failed to encode control response", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, session.ErrSessionNotFound), errors.Is(err, session.ErrClientNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, session.ErrNameInUse):
+		status = http.StatusConflict
+	case errors.Is(err, session.ErrInvalidName), errors.Is(err, session.ErrInvalidSignal):
+		status = http.StatusBadRequest
+	case errors.Is(err, session.ErrSessionExited), errors.Is(err, session.ErrNotSignalable):
+		status = http.StatusConflict
+	case errors.Is(err, jobs.ErrJobNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, jobs.ErrInvalidCommand):
+		status = http.StatusBadRequest
+	case errors.Is(err, jobs.ErrJobRunning), errors.Is(err, jobs.ErrJobFinished), errors.Is(err, jobs.ErrNotKillable):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// splitPath splits an escaped URL path into its unescaped parts, so names
+// containing escaped characters such as spaces come through intact
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil
+	}
+
+	parts := strings.Split(trimmed, "/")
+	for i, part := range parts {
+		if unescaped, err := url.PathUnescape(part); err == nil {
+			parts[i] = unescaped
+		}
+	}
+	return parts
+}
+
+// ============================================================================
+// SERVER
+// ============================================================================
+
+// NewServer creates a control server for the given session and job managers
+func NewServer(sessions *session.Manager, jobs *jobs.Manager) *Server {
+	return &Server{sessions: sessions, jobs: jobs}
+}
+
+// Handler returns the HTTP handler implementing the control API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", s.handleSessions)
+	mux.HandleFunc("/sessions/", s.handleSession)
+	mux.HandleFunc("/clients/", s.handleClient)
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+// Listen binds the control socket. A socket file left behind by a server
+// that is gone is replaced; one that still accepts connections belongs to a
+// running server and is left alone.
+func (s *Server) Listen(socketPath string) error {
+	if conn, err := net.DialTimeout("unix", socketPath, probeTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is in use by another server", socketPath)
+	}
+
+	// The socket is bound in a private directory and only moved into place
+	// once it has its permissions, so it is never reachable with the
+	// looser ones the umask would give it
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".portty-control-*")
+	if err != nil {
+		return fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	boundPath := filepath.Join(dir, "control.sock")
+	listener, err := net.Listen("unix", boundPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	// Close removes the socket under its final name
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(boundPath, cfg.Server.ControlSocketPermissions); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set control socket permissions: %w", err)
+	}
+	if err := os.Rename(boundPath, socketPath); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to move control socket into place: %w", err)
+	}
+
+	s.socketPath = socketPath
+	s.listener = listener
+	s.httpServer = &http.Server{Handler: s.Handler()}
+
+	logger.ControlLogger.Info("Control socket listening", logger.String("path", socketPath))
+	return nil
+}
+
+// Serve handles control requests until the server is closed
+func (s *Server) Serve() error {
+	if s.listener == nil {
+		return fmt.Errorf("control server is not listening")
+	}
+
+	if err := s.httpServer.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("control server failed: %w", err)
+	}
+	return nil
+}
+
+// Close stops the control server and removes its socket
+func (s *Server) Close() error {
+	if s.httpServer == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	err := s.httpServer.Shutdown(ctx)
+	if removeErr := os.Remove(s.socketPath); removeErr != nil && !os.IsNotExist(removeErr) {
+		logger.ControlLogger.Warn("failed to remove control socket", logger.String("path", s.socketPath), logger.Error(removeErr))
+	}
+	return err
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.sessions.List())
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, metrics.Take())
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(strings.TrimPrefix(r.URL.EscapedPath(), "/sessions"))
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		target, ok := s.sessions.Get(parts[0])
+		if !ok {
+			writeError(w, session.ErrSessionNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, target.Info())
+
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if err := s.sessions.Kill(parts[0]); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 2 && parts[1] == "rename" && r.Method == http.MethodPost:
+		var request renameRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+			return
+		}
+		if err := s.sessions.Rename(parts[0], request.Name); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 2 && parts[1] == "signal" && r.Method == http.MethodPost:
+		var request signalRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+			return
+		}
+		if err := s.sessions.Signal(parts[0], request.Signal, request.Target); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleClient(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(strings.TrimPrefix(r.URL.EscapedPath(), "/clients"))
+	if len(parts) != 1 || r.Method != http.MethodDelete {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := s.sessions.DisconnectClient(parts[0]); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.jobs.List())
+
+	case http.MethodPost:
+		var spec jobs.Spec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+			return
+		}
+		job, err := s.jobs.Start(spec)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, job.Info())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(strings.TrimPrefix(r.URL.EscapedPath(), "/jobs"))
+	if len(parts) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	job, ok := s.jobs.Get(parts[0])
+	if !ok {
+		writeError(w, jobs.ErrJobNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, job.Info())
+
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if err := s.jobs.Remove(parts[0]); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 2 && parts[1] == "output" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write(job.Output())
+
+	case len(parts) == 2 && parts[1] == "kill" && r.Method == http.MethodPost:
+		if err := s.jobs.Kill(parts[0]); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ============================================================================
+// CLIENT
+// ============================================================================
+
+// NewClient creates a control client for the socket at socketPath
+func NewClient(socketPath string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &Client{
+		socketPath: socketPath,
+		httpClient: &http.Client{Transport: transport, Timeout: clientTimeout},
+	}
+}
+
+func (c *Client) do(method, path string, body interface{}, result interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		payload = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequest(method, "http://portty"+path, payload)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach PorTTY control socket %s: %w", c.socketPath, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		var errResponse errorResponse
+		if err := json.NewDecoder(response.Body).Decode(&errResponse); err == nil && errResponse.Error != "" {
+			return errors.New(errResponse.Error)
+		}
+		return fmt.Errorf("control request failed: %s", response.Status)
+	}
+
+	if raw, ok := result.(*[]byte); ok {
+		data, err := io.ReadAll(response.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		*raw = data
+		return nil
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListSessions returns all sessions on the running server
+func (c *Client) ListSessions() ([]session.Info, error) {
+	var sessions []session.Info
+	if err := c.do(http.MethodGet, "/sessions", nil, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// KillSession terminates a session by ID or name
+func (c *Client) KillSession(idOrName string) error {
+	return c.do(http.MethodDelete, "/sessions/"+url.PathEscape(idOrName), nil, nil)
+}
+
+// RenameSession assigns a new name to a session
+func (c *Client) RenameSession(idOrName, newName string) error {
+	return c.do(http.MethodPost, "/sessions/"+url.PathEscape(idOrName)+"/rename", renameRequest{Name: newName}, nil)
+}
+
+// SignalSession delivers a signal to a session's foreground process group or,
+// with protocol.SignalTargetSession, to all of its processes
+func (c *Client) SignalSession(idOrName, signal, target string) error {
+	return c.do(http.MethodPost, "/sessions/"+url.PathEscape(idOrName)+"/signal", signalRequest{Signal: signal, Target: target}, nil)
+}
+
+// DisconnectClient detaches a client from its session
+func (c *Client) DisconnectClient(clientID string) error {
+	return c.do(http.MethodDelete, "/clients/"+url.PathEscape(clientID), nil, nil)
+}
+
+// Metrics returns the performance counters of the running server
+func (c *Client) Metrics() (metrics.Snapshot, error) {
+	var snapshot metrics.Snapshot
+	err := c.do(http.MethodGet, "/metrics", nil, &snapshot)
+	return snapshot, err
+}
+
+// ListJobs returns all jobs on the running server
+func (c *Client) ListJobs() ([]jobs.Info, error) {
+	var list []jobs.Info
+	if err := c.do(http.MethodGet, "/jobs", nil, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// StartJob runs a command as a job on the server
+func (c *Client) StartJob(spec jobs.Spec) (jobs.Info, error) {
+	var info jobs.Info
+	err := c.do(http.MethodPost, "/jobs", spec, &info)
+	return info, err
+}
+
+// GetJob describes a single job
+func (c *Client) GetJob(id string) (jobs.Info, error) {
+	var info jobs.Info
+	err := c.do(http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &info)
+	return info, err
+}
+
+// JobOutput returns the retained output of a job
+func (c *Client) JobOutput(id string) ([]byte, error) {
+	var output []byte
+	err := c.do(http.MethodGet, "/jobs/"+url.PathEscape(id)+"/output", nil, &output)
+	return output, err
+}
+
+// KillJob terminates a running job
+func (c *Client) KillJob(id string) error {
+	return c.do(http.MethodPost, "/jobs/"+url.PathEscape(id)+"/kill", nil, nil)
+}
+
+// RemoveJob forgets a finished job and its output
+func (c *Client) RemoveJob(id string) error {
+	return c.do(http.MethodDelete, "/jobs/"+url.PathEscape(id), nil, nil)
+}
+
+// ============================================================================
+// INTERFACE COMPLIANCE CHECKS
+// ============================================================================
+
+var (
+	_ interfaces.ControlServer = (*Server)(nil)
+)
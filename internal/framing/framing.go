@@ -0,0 +1,258 @@
+package framing
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"bytes"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+// Replacement is written in place of bytes that are not valid UTF-8
+const Replacement = "\uFFFD"
+
+// maxCarry bounds how much of an unfinished escape sequence is held back.
+// Longer strings, such as a large OSC 52 clipboard update, are passed on in
+// pieces; UTF-8 sequences are still never split.
+const maxCarry = 64 * 1024
+
+const (
+	esc = 0x1b
+	bel = 0x07
+	can = 0x18
+	sub = 0x1a
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// Options configures a Framer
+type Options struct {
+	// CarryEscapes also holds back unfinished escape sequences, not just
+	// unfinished UTF-8 sequences
+	CarryEscapes bool
+	// CarryTimeout is how long held back bytes wait for the rest of their
+	// sequence before they are passed on anyway
+	CarryTimeout time.Duration
+	// ReplaceInvalid replaces bytes that are not valid UTF-8
+	ReplaceInvalid bool
+}
+
+// Framer cuts terminal output at character and escape sequence boundaries.
+// A read from the PTY may end in the middle of a multibyte character or an
+// escape sequence; the Framer passes on everything up to there and holds
+// the rest back until the next write completes it, so every chunk it emits
+// stands on its own.
+type Framer struct {
+	options Options
+	emit    func([]byte)
+
+	mu      sync.Mutex
+	pending []byte
+	timer   *time.Timer
+	// generation identifies the current timer, so a timer that fires while
+	// a write is replacing it does nothing
+	generation uint64
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// DefaultOptions returns the framing options from the configuration
+func DefaultOptions() Options {
+	return Options{
+		CarryEscapes:   cfg.WebSocket.CarryEscapeSequences,
+		CarryTimeout:   cfg.WebSocket.CarryTimeout,
+		ReplaceInvalid: cfg.WebSocket.ReplaceInvalidUTF8,
+	}
+}
+
+// ValidUTF8 returns data with every run of invalid bytes replaced
+func ValidUTF8(data []byte) []byte {
+	if utf8.Valid(data) {
+		return data
+	}
+	return bytes.ToValidUTF8(data, []byte(Replacement))
+}
+
+// incompleteTail returns where the unfinished sequence at the end of data
+// starts, or len(data) if data ends on a boundary
+func incompleteTail(data []byte, escapes bool) int {
+	for i := 0; i < len(data); {
+		b := data[i]
+		switch {
+		case escapes && b == esc:
+			end, ok := escapeEnd(data, i)
+			if !ok {
+				return i
+			}
+			i = end
+		case b < utf8.RuneSelf:
+			i++
+		case !utf8.FullRune(data[i:]):
+			return i
+		default:
+			_, size := utf8.DecodeRune(data[i:])
+			i += size
+		}
+	}
+	return len(data)
+}
+
+// escapeEnd returns the end of the escape sequence starting at start and
+// whether it is complete
+func escapeEnd(data []byte, start int) (int, bool) {
+	i := start + 1
+	if i >= len(data) {
+		return 0, false
+	}
+
+	switch data[i] {
+	case '[':
+		// CSI: parameters and intermediates up to a final byte
+		for i++; i < len(data); i++ {
+			switch c := data[i]; {
+			case c >= 0x40 && c <= 0x7e, c == can, c == sub:
+				return i + 1, true
+			case c == esc:
+				// An escape cancels the sequence and starts another
+				return i, true
+			}
+		}
+		return 0, false
+
+	case ']', 'P', '_', '^', 'X':
+		// OSC, DCS, APC, PM and SOS: a string up to BEL or ST
+		for i++; i < len(data); i++ {
+			switch data[i] {
+			case bel, can, sub:
+				return i + 1, true
+			case esc:
+				if i+1 >= len(data) {
+					return 0, false
+				}
+				if data[i+1] == '\\' {
+					return i + 2, true
+				}
+				return i, true
+			}
+		}
+		return 0, false
+	}
+
+	// Intermediates up to a final byte, as in ESC ( B
+	for i < len(data) && data[i] >= 0x20 && data[i] <= 0x2f {
+		i++
+	}
+	if i >= len(data) {
+		return 0, false
+	}
+	return i + 1, true
+}
+
+// utf8Tail returns where an unfinished UTF-8 sequence at the end of data
+// starts, or len(data)
+func utf8Tail(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// New creates a Framer that passes complete output to emit. emit is called
+// from one goroutine at a time, in order.
+func New(options Options, emit func([]byte)) *Framer {
+	return &Framer{options: options, emit: emit}
+}
+
+// Write passes on data up to the last complete character or escape
+// sequence and holds the rest back. The Framer keeps data, so the caller
+// must not reuse it.
+func (f *Framer) Write(data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stopTimerLocked()
+
+	buf := data
+	if len(f.pending) > 0 {
+		buf = append(f.pending, data...)
+		f.pending = nil
+	}
+
+	cut := incompleteTail(buf, f.options.CarryEscapes)
+	if len(buf)-cut > maxCarry {
+		cut = utf8Tail(buf)
+	}
+	if cut < len(buf) {
+		f.pending = append([]byte(nil), buf[cut:]...)
+		generation := f.generation
+		f.timer = time.AfterFunc(f.options.CarryTimeout, func() { f.expire(generation) })
+	}
+
+	f.emitLocked(buf[:cut])
+}
+
+// Flush passes on anything held back
+func (f *Framer) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stopTimerLocked()
+	f.flushLocked()
+}
+
+func (f *Framer) expire(generation uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if generation == f.generation {
+		f.flushLocked()
+	}
+}
+
+func (f *Framer) flushLocked() {
+	pending := f.pending
+	f.pending = nil
+	f.emitLocked(pending)
+}
+
+func (f *Framer) stopTimerLocked() {
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.generation++
+}
+
+func (f *Framer) emitLocked(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if f.options.ReplaceInvalid {
+		data = ValidUTF8(data)
+	}
+	f.emit(data)
+}
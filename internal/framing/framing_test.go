@@ -0,0 +1,238 @@
+package framing
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// pieces are the building blocks of generated terminal output
+var pieces = map[string][]string{
+	"utf8": {"a", "hello ", "é", "日本語", "🎉", "\r\n", "ß", "𝄞"},
+	"csi":  {"x", "\x1b[31m", "\x1b[0m", "\x1b[?2004h", "\x1b[38;2;255;128;0m", "\x1b[2J", "\x1b[10;20H", "ü"},
+	"osc":  {"y", "\x1b]0;title ü\x07", "\x1b]52;c;aGVsbG8=\x1b\\", "\x1b]8;;https://example.com\x1b\\", "日"},
+	"dcs":  {"z", "\x1bP1$r0m\x1b\\", "\x1bP+q544e\x1b\\", "\x1b_apc\x1b\\", "\x1b(B", "\x1bM", "🎉"},
+}
+
+// generate builds a stream of n random pieces of the given kinds and
+// returns it with the offsets where an escape sequence or a character
+// outside one ends
+func generate(rng *rand.Rand, n int, kinds ...string) ([]byte, map[int]bool) {
+	var choices []string
+	for _, kind := range kinds {
+		choices = append(choices, pieces[kind]...)
+	}
+	var out []byte
+	ends := map[int]bool{}
+	for i := 0; i < n; i++ {
+		piece := choices[rng.Intn(len(choices))]
+		if piece[0] == esc {
+			out = append(out, piece...)
+			ends[len(out)] = true
+			continue
+		}
+		for _, r := range piece {
+			out = utf8.AppendRune(out, r)
+			ends[len(out)] = true
+		}
+	}
+	return out, ends
+}
+
+// run feeds input to a Framer in writes split at the given offsets. It
+// returns the frames emitted while writing and everything emitted, which
+// includes the final flush.
+func run(options Options, input []byte, splits []int) (written [][]byte, all []byte) {
+	var frames [][]byte
+	f := New(options, func(data []byte) {
+		frames = append(frames, append([]byte(nil), data...))
+	})
+
+	start := 0
+	for _, end := range append(splits[:len(splits):len(splits)], len(input)) {
+		f.Write(append([]byte(nil), input[start:end]...))
+		start = end
+	}
+	written = frames
+	f.Flush()
+	return written, bytes.Join(frames, nil)
+}
+
+// randomSplits returns sorted offsets cutting input into random pieces
+func randomSplits(rng *rand.Rand, length int) []int {
+	var splits []int
+	for offset := 1 + rng.Intn(16); offset < length; offset += 1 + rng.Intn(16) {
+		splits = append(splits, offset)
+	}
+	return splits
+}
+
+// boundaries returns the offsets in data where a character or, with
+// escapes, an escape sequence ends, reading data as a whole
+func boundaries(data []byte, escapes bool) map[int]bool {
+	ends := map[int]bool{}
+	for i := 0; i < len(data); {
+		switch {
+		case escapes && data[i] == esc:
+			end, ok := escapeEnd(data, i)
+			if !ok {
+				return ends
+			}
+			i = end
+		case !utf8.FullRune(data[i:]):
+			return ends
+		default:
+			_, size := utf8.DecodeRune(data[i:])
+			i += size
+		}
+		ends[i] = true
+	}
+	return ends
+}
+
+// checkFrames fails if a frame emitted by a write doesn't end at one of ends
+func checkFrames(t testing.TB, frames [][]byte, ends map[int]bool) {
+	t.Helper()
+	offset := 0
+	for _, frame := range frames {
+		offset += len(frame)
+		if !ends[offset] {
+			t.Fatalf("frame ends inside a sequence at offset %d: %q", offset, frame)
+		}
+	}
+}
+
+func TestFramerRandomSplits(t *testing.T) {
+	tests := []struct {
+		name  string
+		kinds []string
+	}{
+		{"utf8", []string{"utf8"}},
+		{"csi", []string{"csi"}},
+		{"osc", []string{"osc"}},
+		{"dcs", []string{"dcs"}},
+		{"mixed", []string{"utf8", "csi", "osc", "dcs"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 500; i++ {
+				input, pieceEnds := generate(rng, 1+rng.Intn(80), tt.kinds...)
+				splits := randomSplits(rng, len(input))
+
+				// Without escapes, frames only need to be whole characters
+				frames, all := run(Options{CarryTimeout: time.Hour}, input, splits)
+				if !bytes.Equal(all, input) {
+					t.Fatalf("output %q differs from input %q", all, input)
+				}
+				for _, frame := range frames {
+					if !utf8.Valid(frame) {
+						t.Fatalf("frame splits a character: %q", frame)
+					}
+				}
+
+				// With escapes, frames end where a generated piece ends
+				frames, all = run(Options{CarryEscapes: true, CarryTimeout: time.Hour}, input, splits)
+				if !bytes.Equal(all, input) {
+					t.Fatalf("output %q differs from input %q", all, input)
+				}
+				checkFrames(t, frames, pieceEnds)
+			}
+		})
+	}
+}
+
+func TestFramerMaxCarry(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	// An OSC 52 update that never ends, longer than what is held back
+	input := append([]byte("\x1b]52;c;"), strings.Repeat("日本🎉", 2*maxCarry/10)...)
+	splits := randomSplits(rng, len(input))
+
+	var emitted int
+	f := New(Options{CarryEscapes: true, CarryTimeout: time.Hour}, func(data []byte) {
+		if tail := utf8Tail(data); tail != len(data) {
+			t.Fatalf("frame ends inside a UTF-8 sequence: %q", data[tail:])
+		}
+		emitted += len(data)
+	})
+	start := 0
+	for _, end := range append(splits, len(input)) {
+		f.Write(append([]byte(nil), input[start:end]...))
+		start = end
+	}
+	if held := len(input) - emitted; held > maxCarry {
+		t.Fatalf("held back %d bytes, more than %d", held, maxCarry)
+	}
+
+	f.Flush()
+	if emitted != len(input) {
+		t.Fatalf("emitted %d of %d bytes", emitted, len(input))
+	}
+}
+
+func TestFramerCarryTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"utf8", "abc\xe6\x97"},
+		{"csi", "abc\x1b[31"},
+		{"osc", "abc\x1b]0;title"},
+		{"dcs", "abc\x1bP1$r"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := make(chan []byte, 4)
+			f := New(Options{CarryEscapes: true, CarryTimeout: 10 * time.Millisecond}, func(data []byte) {
+				frames <- append([]byte(nil), data...)
+			})
+			f.Write([]byte(tt.input))
+
+			var got []byte
+			deadline := time.After(time.Second)
+			for len(got) < len(tt.input) {
+				select {
+				case frame := <-frames:
+					got = append(got, frame...)
+				case <-deadline:
+					t.Fatalf("only %q delivered without a flush", got)
+				}
+			}
+			if string(got) != tt.input {
+				t.Fatalf("delivered %q, want %q", got, tt.input)
+			}
+		})
+	}
+}
+
+func FuzzFramer(f *testing.F) {
+	rng := rand.New(rand.NewSource(3))
+	for _, kind := range []string{"utf8", "csi", "osc", "dcs"} {
+		input, _ := generate(rng, 20, kind)
+		f.Add(input, int64(rng.Int()))
+	}
+	f.Add([]byte("\x1b]0;unterminated 日本"), int64(1))
+	f.Add([]byte("\xff\xfe invalid \xe6\x97"), int64(2))
+	f.Add([]byte("\xc3\xc3"), int64(6560061893525691197))
+
+	f.Fuzz(func(t *testing.T, input []byte, seed int64) {
+		splits := randomSplits(rand.New(rand.NewSource(seed)), len(input))
+
+		for _, escapes := range []bool{false, true} {
+			options := Options{CarryEscapes: escapes, CarryTimeout: time.Hour}
+			frames, all := run(options, input, splits)
+			if !bytes.Equal(all, input) {
+				t.Fatalf("output %q differs from input %q", all, input)
+			}
+			// Beyond maxCarry frames may end inside an escape sequence
+			if len(input) <= maxCarry {
+				checkFrames(t, frames, boundaries(input, escapes))
+			}
+		}
+	})
+}
@@ -8,6 +8,8 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"os"
+	"os/exec"
 )
 
 // ============================================================================
@@ -34,7 +36,8 @@ type PTYInputProcessor interface {
 	ProcessInput(ctx context.Context, data []byte) error
 }
 
-// PTYLifecycle defines the interface for PTY lifecycle management
+// PTYLifecycle defines the interface for PTY lifecycle management. Close
+// terminates the process behind the PTY and Done is closed once it has exited.
 type PTYLifecycle interface {
 	Close() error
 	Done() <-chan struct{}
@@ -60,6 +63,84 @@ type PTYBridge interface {
 	PTYCopier
 }
 
+// PTYEventSource defines the interface for bridges that emit structured
+// control messages alongside raw terminal output
+type PTYEventSource interface {
+	Events() <-chan []byte
+}
+
+// PTYStateReporter defines the interface for event sources whose state has to
+// be described to each client that attaches, since it isn't in the output
+type PTYStateReporter interface {
+	State() [][]byte
+}
+
+// PTYExitReporter defines the interface for bridges that reap their process
+// and report how it terminated
+type PTYExitReporter interface {
+	Exited() <-chan struct{}
+	ExitStatus() (code int, signal os.Signal)
+}
+
+// PTYDirectoryReporter defines the interface for bridges that can tell the
+// current directory of the program the user is looking at
+type PTYDirectoryReporter interface {
+	WorkingDirectory() string
+}
+
+// PTYProcessInspector defines the interface for bridges that can describe the
+// state of the process running behind the PTY
+type PTYProcessInspector interface {
+	PTYDirectoryReporter
+	Environment() []string
+	ForegroundProcess() (pid int, args []string, cwd string)
+}
+
+// PTYSignaler defines the interface for bridges that can deliver signals to
+// the processes behind the PTY
+type PTYSignaler interface {
+	Signal(signal, target string) error
+}
+
+// PTYOptions describes how the process behind a new PTY is launched; zero
+// values select the server defaults
+type PTYOptions struct {
+	Dir         string
+	Env         []string
+	Rows        int
+	Cols        int
+	Width       int
+	Height      int
+	Profile     string
+	Command     string
+	Args        []string
+	Term        string
+	ColorTerm   string
+	Multiplexer string
+}
+
+// ============================================================================
+// MULTIPLEXER INTERFACES
+// ============================================================================
+
+// Multiplexer defines the interface for terminal multiplexers that keep
+// sessions running independently of the PTY attached to them
+type Multiplexer interface {
+	Name() string
+	Installed() bool
+	Exists(ctx context.Context, session string) bool
+	CreateCommand(ctx context.Context, session string, options PTYOptions) (*exec.Cmd, error)
+	AttachCommand(ctx context.Context, session string) *exec.Cmd
+	List(ctx context.Context) ([]string, error)
+	Kill(ctx context.Context, session string) error
+}
+
+// MultiplexerPaneInspector defines the interface for multiplexers that can
+// describe the active pane of a session
+type MultiplexerPaneInspector interface {
+	PaneDirectory(ctx context.Context, session string) (string, error)
+}
+
 // ============================================================================
 // WEBSOCKET INTERFACES
 // ============================================================================
@@ -102,8 +183,6 @@ type AddressParser interface {
 
 // ProcessManager defines the interface for process management operations
 type ProcessManager interface {
-	CheckTmuxInstalled() bool
-	CheckSessionExists(sessionName string) bool
 	FindAndKillProcess() error
 	StopServer(pidFilePath string) error
 }
@@ -115,9 +194,17 @@ type PIDFileManager interface {
 	RemovePIDFile(pidFilePath string) error
 }
 
-// TmuxSessionManager defines the interface for tmux session management
-type TmuxSessionManager interface {
-	CleanupTmuxSessions(ctx context.Context) error
+// MultiplexerSessionManager defines the interface for cleaning up the
+// multiplexer sessions started by the server
+type MultiplexerSessionManager interface {
+	CleanupSessions(ctx context.Context) error
+}
+
+// ControlServer defines the interface for the local session management channel
+type ControlServer interface {
+	Listen(socketPath string) error
+	Serve() error
+	Close() error
 }
 
 // HTTPServerManager defines the interface for HTTP server operations
@@ -137,7 +224,7 @@ type HTTPServer interface {
 
 // PTYBridgeFactory defines the interface for creating PTY bridges
 type PTYBridgeFactory interface {
-	NewPTYBridge(ctx context.Context) (PTYBridge, error)
+	NewPTYBridge(ctx context.Context, options PTYOptions) (PTYBridge, error)
 }
 
 // WebSocketHandlerFactory defines the interface for creating WebSocket handlers
@@ -151,9 +238,10 @@ type ServerFactory interface {
 		addressParser AddressParser,
 		processManager ProcessManager,
 		pidFileManager PIDFileManager,
-		tmuxManager TmuxSessionManager,
+		multiplexerManager MultiplexerSessionManager,
 		httpManager HTTPServerManager,
 		wsHandler WebSocketHandler,
+		controlServer ControlServer,
 	) ServerManager
 }
 
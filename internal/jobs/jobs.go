@@ -0,0 +1,526 @@
+package jobs
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+	"github.com/PiTZE/PorTTY/internal/framing"
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+const (
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrInvalidCommand = errors.New("invalid job command")
+	ErrJobRunning     = errors.New("job is still running")
+	ErrJobFinished    = errors.New("job has already finished")
+	ErrNotKillable    = errors.New("job does not support signals")
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// Spec describes the command a job runs
+type Spec struct {
+	Command []string `json:"command"`
+	Cwd     string   `json:"cwd,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Rows    int      `json:"rows,omitempty"`
+	Cols    int      `json:"cols,omitempty"`
+}
+
+// Job is a command running in a PTY without an interactive client. Its
+// output and exit status are kept after it finishes.
+type Job struct {
+	id        string
+	spec      Spec
+	startedAt time.Time
+	bridge    interfaces.PTYBridge
+
+	mu         sync.Mutex
+	output     []byte
+	dropped    int64
+	state      string
+	exitCode   int
+	signal     string
+	finishedAt time.Time
+	watchers   map[int]chan []byte
+	nextWatch  int
+
+	done chan struct{}
+}
+
+// Manager tracks running and finished jobs
+type Manager struct {
+	ptyFactory interfaces.PTYBridgeFactory
+	ctx        context.Context
+	cancel     context.CancelFunc
+	mu         sync.RWMutex
+	jobs       map[string]*Job
+}
+
+// Info describes a job and, once it has finished, how it ended
+type Info struct {
+	ID          string     `json:"id"`
+	Command     []string   `json:"command"`
+	Cwd         string     `json:"cwd,omitempty"`
+	State       string     `json:"state"`
+	ExitCode    *int       `json:"exit_code,omitempty"`
+	Signal      string     `json:"signal,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	OutputBytes int64      `json:"output_bytes"`
+	Truncated   bool       `json:"truncated,omitempty"`
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+func generateID() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (s Spec) validate() error {
+	if len(s.Command) == 0 || strings.TrimSpace(s.Command[0]) == "" {
+		return fmt.Errorf("%w: no command given", ErrInvalidCommand)
+	}
+	if s.Cwd != "" {
+		info, err := os.Stat(s.Cwd)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("%w: %s is not a directory", ErrInvalidCommand, s.Cwd)
+		}
+	}
+	return nil
+}
+
+func (s Spec) launch() interfaces.PTYOptions {
+	return interfaces.PTYOptions{
+		Command: s.Command[0],
+		Args:    s.Command[1:],
+		Dir:     s.Cwd,
+		Env:     s.Env,
+		Rows:    s.Rows,
+		Cols:    s.Cols,
+	}
+}
+
+// ============================================================================
+// JOB
+// ============================================================================
+
+// ID returns the unique job identifier
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Done is closed once the job's process has exited and all of its output
+// has been collected
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Info describes the job's current state
+func (j *Job) Info() Info {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := Info{
+		ID:          j.id,
+		Command:     j.spec.Command,
+		Cwd:         j.spec.Cwd,
+		State:       j.state,
+		StartedAt:   j.startedAt,
+		OutputBytes: j.dropped + int64(len(j.output)),
+		Truncated:   j.dropped > 0 || (cfg.Jobs.OutputLimit > 0 && len(j.output) > cfg.Jobs.OutputLimit),
+	}
+	if j.state != StateRunning {
+		code, finishedAt := j.exitCode, j.finishedAt
+		info.ExitCode = &code
+		info.Signal = j.signal
+		info.FinishedAt = &finishedAt
+	}
+	return info
+}
+
+// Output returns a copy of the retained output
+func (j *Job) Output() []byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.retained()
+}
+
+// Exited describes how the job ended for clients watching it; ok is false
+// while the job is still running
+func (j *Job) Exited() (protocol.ExitedMessage, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.state == StateRunning {
+		return protocol.ExitedMessage{}, false
+	}
+
+	message := protocol.ExitedMessage{
+		Type:   protocol.TypeExited,
+		Code:   j.exitCode,
+		Action: protocol.ExitActionClose,
+	}
+	if j.signal != "" {
+		message.Signal = j.exitCode - 128
+		message.SignalName = j.signal
+	}
+	return message, true
+}
+
+// Watch returns the output so far and a channel carrying output produced
+// after it. The channel is closed when the job finishes or when the watcher
+// falls too far behind; cancel stops watching early.
+func (j *Job) Watch() ([]byte, <-chan []byte, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	snapshot := j.retained()
+	output := make(chan []byte, cfg.WebSocket.MessageChannelBuffer)
+	if j.state != StateRunning {
+		close(output)
+		return snapshot, output, func() {}
+	}
+
+	id := j.nextWatch
+	j.nextWatch++
+	j.watchers[id] = output
+
+	cancel := func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if watcher, ok := j.watchers[id]; ok {
+			delete(j.watchers, id)
+			close(watcher)
+		}
+	}
+	return snapshot, output, cancel
+}
+
+// Signal delivers a signal to every process in the job
+func (j *Job) Signal(name string) error {
+	select {
+	case <-j.done:
+		return ErrJobFinished
+	default:
+	}
+
+	signaler, ok := j.bridge.(interfaces.PTYSignaler)
+	if !ok {
+		return ErrNotKillable
+	}
+	return signaler.Signal(name, protocol.SignalTargetSession)
+}
+
+// append records output, keeping at most the configured limit of the most
+// recent bytes, and forwards it to watchers
+func (j *Job) append(data []byte) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.output = append(j.output, data...)
+	if limit := cfg.Jobs.OutputLimit; limit > 0 && len(j.output) > 2*limit {
+		j.trimOutput()
+	}
+
+	for id, watcher := range j.watchers {
+		select {
+		case watcher <- data:
+		default:
+			logger.JobsLogger.Warn("job watcher fell behind, disconnecting", logger.String("job", j.id))
+			delete(j.watchers, id)
+			close(watcher)
+		}
+	}
+}
+
+// retained returns a copy of at most the configured limit of the most
+// recent output; callers must hold j.mu
+func (j *Job) retained() []byte {
+	data := j.output
+	if limit := cfg.Jobs.OutputLimit; limit > 0 && len(data) > limit {
+		data = data[len(data)-limit:]
+	}
+	return append([]byte(nil), data...)
+}
+
+// trimOutput discards output beyond the configured limit; callers must hold
+// j.mu
+func (j *Job) trimOutput() {
+	if limit := cfg.Jobs.OutputLimit; limit > 0 && len(j.output) > limit {
+		excess := len(j.output) - limit
+		j.dropped += int64(excess)
+		j.output = append([]byte(nil), j.output[excess:]...)
+	}
+}
+
+// run collects output until the process exits and records its status
+func (j *Job) run(ctx context.Context) {
+	framer := framing.New(framing.DefaultOptions(), j.append)
+	buf := make([]byte, cfg.WebSocket.MaxMessageSize)
+	for {
+		n, err := j.bridge.Read(ctx, buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			framer.Write(data)
+		}
+
+		if err != nil {
+			if err == io.EOF || err == io.ErrClosedPipe || err == io.ErrUnexpectedEOF ||
+				err == context.Canceled || err == context.DeadlineExceeded {
+				break
+			}
+
+			select {
+			case <-time.After(cfg.WebSocket.ErrorRetryDelay):
+				continue
+			case <-j.bridge.Done():
+			case <-ctx.Done():
+			}
+			break
+		}
+	}
+
+	framer.Flush()
+
+	// Closing reaps the process, first terminating anything it left running
+	j.bridge.Close()
+
+	code, signal := -1, ""
+	if reporter, ok := j.bridge.(interfaces.PTYExitReporter); ok {
+		var sig os.Signal
+		code, sig = reporter.ExitStatus()
+		if s, ok := sig.(syscall.Signal); ok {
+			signal = s.String()
+		}
+	}
+
+	j.mu.Lock()
+	j.exitCode, j.signal = code, signal
+	j.finishedAt = time.Now()
+	j.state = StateFailed
+	if code == 0 {
+		j.state = StateSucceeded
+	}
+	j.trimOutput()
+	for id, watcher := range j.watchers {
+		delete(j.watchers, id)
+		close(watcher)
+	}
+	j.mu.Unlock()
+	close(j.done)
+
+	logger.JobsLogger.Info("Job finished",
+		logger.String("job", j.id),
+		logger.String("state", j.state),
+		logger.Int("code", code))
+}
+
+// ============================================================================
+// MANAGER
+// ============================================================================
+
+// NewManager creates a job manager backed by the given PTY factory
+func NewManager(ptyFactory interfaces.PTYBridgeFactory) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		ptyFactory: ptyFactory,
+		ctx:        ctx,
+		cancel:     cancel,
+		jobs:       make(map[string]*Job),
+	}
+}
+
+// Start launches a job. Jobs run independently of any client and keep
+// running until their command exits or the server shuts down.
+func (m *Manager) Start(spec Spec) (*Job, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	bridge, err := m.ptyFactory.NewPTYBridge(m.ctx, spec.launch())
+	if err != nil {
+		return nil, fmt.Errorf("failed to start job: %w", err)
+	}
+
+	job := &Job{
+		id:        generateID(),
+		spec:      spec,
+		startedAt: time.Now(),
+		bridge:    bridge,
+		state:     StateRunning,
+		watchers:  make(map[int]chan []byte),
+		done:      make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.jobs[job.id] = job
+	m.mu.Unlock()
+
+	logger.JobsLogger.Info("Job started",
+		logger.String("job", job.id),
+		logger.String("command", strings.Join(spec.Command, " ")))
+
+	go func() {
+		job.run(m.ctx)
+		m.prune()
+	}()
+	return job, nil
+}
+
+// Get looks up a job by ID
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List describes all jobs, oldest first
+func (m *Manager) List() []Info {
+	m.mu.RLock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].startedAt.Before(jobs[j].startedAt)
+	})
+
+	infos := make([]Info, 0, len(jobs))
+	for _, job := range jobs {
+		infos = append(infos, job.Info())
+	}
+	return infos
+}
+
+// Kill terminates a running job, following up with SIGKILL if it has not
+// exited within the configured timeout
+func (m *Manager) Kill(id string) error {
+	job, ok := m.Get(id)
+	if !ok {
+		return ErrJobNotFound
+	}
+	if err := job.Signal("SIGTERM"); err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case <-job.done:
+		case <-time.After(cfg.Jobs.KillTimeout):
+			logger.JobsLogger.Warn("Job ignored SIGTERM, killing", logger.String("job", job.id))
+			job.Signal("SIGKILL")
+		}
+	}()
+	return nil
+}
+
+// Remove forgets a finished job and its output
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	select {
+	case <-job.done:
+	default:
+		return ErrJobRunning
+	}
+	delete(m.jobs, id)
+	return nil
+}
+
+// prune forgets the oldest finished jobs beyond the retention limit
+func (m *Manager) prune() {
+	limit := cfg.Jobs.MaxRetained
+	if limit <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var finished []*Job
+	for _, job := range m.jobs {
+		select {
+		case <-job.done:
+			finished = append(finished, job)
+		default:
+		}
+	}
+	if len(finished) <= limit {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].startedAt.Before(finished[j].startedAt)
+	})
+	for _, job := range finished[:len(finished)-limit] {
+		delete(m.jobs, job.id)
+	}
+}
+
+// Shutdown terminates all running jobs and waits for them to exit
+func (m *Manager) Shutdown() {
+	m.cancel()
+
+	m.mu.RLock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mu.RUnlock()
+
+	deadline := time.After(cfg.Server.HangupGracePeriod + cfg.Server.TerminateGracePeriod + cfg.Server.PTYOperationTimeout)
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+		case <-deadline:
+			return
+		}
+	}
+}
@@ -110,4 +110,7 @@ var (
 	ServerLogger    = New("server")
 	WebSocketLogger = New("websocket")
 	PTYBridgeLogger = New("ptybridge")
+	SessionLogger   = New("session")
+	ControlLogger   = New("control")
+	JobsLogger      = New("jobs")
 )
@@ -0,0 +1,231 @@
+package metrics
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+// Output counts terminal output on its way from sessions to WebSocket clients
+var Output = &OutputMetrics{}
+
+// Compression counts permessage-deflate compression of output frames
+var Compression = &CompressionMetrics{}
+
+// Latency counts round trip times measured on client connections
+var Latency = &LatencyMetrics{}
+
+var started = time.Now()
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// Counter is a monotonically increasing value safe for concurrent use
+type Counter struct {
+	value atomic.Int64
+}
+
+// OutputMetrics counts output per connection. Chunks are the pieces of output
+// handed to a connection, one per PTY read; frames are the WebSocket messages
+// they were coalesced into.
+type OutputMetrics struct {
+	Chunks Counter
+	Frames Counter
+	Bytes  Counter
+
+	// Why each frame was flushed
+	FlushSize  Counter
+	FlushDelay Counter
+	FlushEcho  Counter
+	FlushEvent Counter
+}
+
+// CompressionMetrics counts output frames on connections that negotiated
+// permessage-deflate. Wire bytes are what the compressed frames took on the
+// connection, frame headers included; time is spent compressing and writing
+// them.
+type CompressionMetrics struct {
+	Connections Counter
+	Frames      Counter
+	Skipped     Counter
+	Bytes       Counter
+	WireBytes   Counter
+	Nanoseconds Counter
+}
+
+// LatencyMetrics counts round trip time samples by how long they took
+type LatencyMetrics struct {
+	Samples     Counter
+	Nanoseconds Counter
+	Under50ms   Counter
+	Under100ms  Counter
+	Under250ms  Counter
+	Under500ms  Counter
+	Over500ms   Counter
+}
+
+// Snapshot is a point-in-time copy of all metrics
+type Snapshot struct {
+	StartedAt   time.Time           `json:"started_at"`
+	Output      OutputSnapshot      `json:"output"`
+	Compression CompressionSnapshot `json:"compression"`
+	Latency     LatencySnapshot     `json:"latency"`
+}
+
+// OutputSnapshot is a copy of OutputMetrics with derived ratios
+type OutputSnapshot struct {
+	Chunks            int64           `json:"chunks"`
+	Frames            int64           `json:"frames"`
+	Bytes             int64           `json:"bytes"`
+	ChunksPerFrame    float64         `json:"chunks_per_frame"`
+	AverageFrameBytes float64         `json:"average_frame_bytes"`
+	Flushes           FlushesSnapshot `json:"flushes"`
+}
+
+// FlushesSnapshot counts flushed frames by reason
+type FlushesSnapshot struct {
+	Size  int64 `json:"size"`
+	Delay int64 `json:"delay"`
+	Echo  int64 `json:"echo"`
+	Event int64 `json:"event"`
+}
+
+// CompressionSnapshot is a copy of CompressionMetrics with derived ratios.
+// Skipped frames were below the compression threshold.
+type CompressionSnapshot struct {
+	Connections        int64   `json:"connections"`
+	Frames             int64   `json:"frames"`
+	Skipped            int64   `json:"skipped"`
+	Bytes              int64   `json:"bytes"`
+	WireBytes          int64   `json:"wire_bytes"`
+	Ratio              float64 `json:"ratio"`
+	Microseconds       int64   `json:"time_us"`
+	MicrosecondsPerMiB float64 `json:"time_us_per_mib"`
+}
+
+// LatencySnapshot is a copy of LatencyMetrics with the average round trip
+// time in milliseconds
+type LatencySnapshot struct {
+	Samples    int64   `json:"samples"`
+	AverageRTT float64 `json:"average_rtt_ms"`
+	Under50ms  int64   `json:"under_50ms"`
+	Under100ms int64   `json:"under_100ms"`
+	Under250ms int64   `json:"under_250ms"`
+	Under500ms int64   `json:"under_500ms"`
+	Over500ms  int64   `json:"over_500ms"`
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// Add increases the counter by n
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Inc increases the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Load returns the current value
+func (c *Counter) Load() int64 {
+	return c.value.Load()
+}
+
+// Take returns a snapshot of all metrics
+func Take() Snapshot {
+	return Snapshot{
+		StartedAt:   started,
+		Output:      Output.snapshot(),
+		Compression: Compression.snapshot(),
+		Latency:     Latency.snapshot(),
+	}
+}
+
+func (m *OutputMetrics) snapshot() OutputSnapshot {
+	snapshot := OutputSnapshot{
+		Chunks: m.Chunks.Load(),
+		Frames: m.Frames.Load(),
+		Bytes:  m.Bytes.Load(),
+		Flushes: FlushesSnapshot{
+			Size:  m.FlushSize.Load(),
+			Delay: m.FlushDelay.Load(),
+			Echo:  m.FlushEcho.Load(),
+			Event: m.FlushEvent.Load(),
+		},
+	}
+	if snapshot.Frames > 0 {
+		snapshot.ChunksPerFrame = float64(snapshot.Chunks) / float64(snapshot.Frames)
+		snapshot.AverageFrameBytes = float64(snapshot.Bytes) / float64(snapshot.Frames)
+	}
+	return snapshot
+}
+
+// Record adds a round trip time sample
+func (m *LatencyMetrics) Record(rtt time.Duration) {
+	m.Samples.Inc()
+	m.Nanoseconds.Add(int64(rtt))
+
+	switch {
+	case rtt < 50*time.Millisecond:
+		m.Under50ms.Inc()
+	case rtt < 100*time.Millisecond:
+		m.Under100ms.Inc()
+	case rtt < 250*time.Millisecond:
+		m.Under250ms.Inc()
+	case rtt < 500*time.Millisecond:
+		m.Under500ms.Inc()
+	default:
+		m.Over500ms.Inc()
+	}
+}
+
+func (m *LatencyMetrics) snapshot() LatencySnapshot {
+	snapshot := LatencySnapshot{
+		Samples:    m.Samples.Load(),
+		Under50ms:  m.Under50ms.Load(),
+		Under100ms: m.Under100ms.Load(),
+		Under250ms: m.Under250ms.Load(),
+		Under500ms: m.Under500ms.Load(),
+		Over500ms:  m.Over500ms.Load(),
+	}
+	if snapshot.Samples > 0 {
+		average := time.Duration(m.Nanoseconds.Load() / snapshot.Samples)
+		snapshot.AverageRTT = Milliseconds(average)
+	}
+	return snapshot
+}
+
+// Milliseconds converts a duration to fractional milliseconds
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (m *CompressionMetrics) snapshot() CompressionSnapshot {
+	elapsed := time.Duration(m.Nanoseconds.Load())
+	snapshot := CompressionSnapshot{
+		Connections:  m.Connections.Load(),
+		Frames:       m.Frames.Load(),
+		Skipped:      m.Skipped.Load(),
+		Bytes:        m.Bytes.Load(),
+		WireBytes:    m.WireBytes.Load(),
+		Microseconds: elapsed.Microseconds(),
+	}
+	if snapshot.WireBytes > 0 {
+		snapshot.Ratio = float64(snapshot.Bytes) / float64(snapshot.WireBytes)
+	}
+	if snapshot.Bytes > 0 {
+		snapshot.MicrosecondsPerMiB = float64(elapsed.Microseconds()) / (float64(snapshot.Bytes) / (1 << 20))
+	}
+	return snapshot
+}
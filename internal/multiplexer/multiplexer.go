@@ -0,0 +1,76 @@
+package multiplexer
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+const (
+	None   = "none"
+	Tmux   = "tmux"
+	Screen = "screen"
+	Zellij = "zellij"
+)
+
+// Names lists the supported multiplexers
+var Names = []string{Tmux, Screen, Zellij}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// Enabled reports whether name selects a multiplexer rather than direct shells
+func Enabled(name string) bool {
+	return name != "" && name != None
+}
+
+// SessionName returns the multiplexer session used for a profile, or the
+// server's session for the default shell
+func SessionName(profile string) string {
+	if profile == "" {
+		return cfg.Server.SessionName
+	}
+	return cfg.Server.SessionName + "-" + profile
+}
+
+// Owned reports whether a multiplexer session was created by PorTTY
+func Owned(session string) bool {
+	return session == cfg.Server.SessionName || strings.HasPrefix(session, cfg.Server.SessionName+"-")
+}
+
+func installed(binary string) bool {
+	_, err := exec.LookPath(binary)
+	return err == nil
+}
+
+// ============================================================================
+// FACTORY FUNCTIONS
+// ============================================================================
+
+// New returns the multiplexer with the given name
+func New(name string) (interfaces.Multiplexer, error) {
+	switch name {
+	case Tmux:
+		return &TmuxMultiplexer{}, nil
+	case Screen:
+		return &ScreenMultiplexer{}, nil
+	case Zellij:
+		return &ZellijMultiplexer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown multiplexer %q (use %s or %s)", name, strings.Join(Names, ", "), None)
+	}
+}
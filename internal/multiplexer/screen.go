@@ -0,0 +1,105 @@
+package multiplexer
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// ScreenMultiplexer runs sessions in GNU screen
+type ScreenMultiplexer struct{}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// parseScreenList extracts session names from `screen -ls` output, where
+// each session is listed as "<pid>.<name>" followed by its state
+func parseScreenList(output string) []string {
+	var sessions []string
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, "\t") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, name, ok := strings.Cut(fields[0], "."); ok {
+			sessions = append(sessions, name)
+		}
+	}
+	return sessions
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+func (s *ScreenMultiplexer) Name() string {
+	return Screen
+}
+
+func (s *ScreenMultiplexer) Installed() bool {
+	return installed("screen")
+}
+
+func (s *ScreenMultiplexer) Exists(ctx context.Context, session string) bool {
+	sessions, err := s.List(ctx)
+	if err != nil {
+		return false
+	}
+	for _, name := range sessions {
+		if name == session {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateCommand starts a new screen session. screen forks its server from
+// this process, so the directory and environment come from the command.
+func (s *ScreenMultiplexer) CreateCommand(ctx context.Context, session string, options interfaces.PTYOptions) (*exec.Cmd, error) {
+	args := []string{"-S", session}
+	if options.Command != "" {
+		args = append(append(args, options.Command), options.Args...)
+	}
+	return exec.CommandContext(ctx, "screen", args...), nil
+}
+
+// AttachCommand joins the session in multi-display mode so several clients
+// can share it
+func (s *ScreenMultiplexer) AttachCommand(ctx context.Context, session string) *exec.Cmd {
+	return exec.CommandContext(ctx, "screen", "-x", session)
+}
+
+func (s *ScreenMultiplexer) List(ctx context.Context) ([]string, error) {
+	// screen -ls exits non-zero even when it lists sessions
+	output, err := exec.CommandContext(ctx, "screen", "-ls").Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, err
+		}
+	}
+	return parseScreenList(string(output)), nil
+}
+
+func (s *ScreenMultiplexer) Kill(ctx context.Context, session string) error {
+	return exec.CommandContext(ctx, "screen", "-S", session, "-X", "quit").Run()
+}
+
+// ============================================================================
+// INTERFACE COMPLIANCE CHECKS
+// ============================================================================
+
+var _ interfaces.Multiplexer = (*ScreenMultiplexer)(nil)
@@ -0,0 +1,92 @@
+package multiplexer
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// TmuxMultiplexer runs sessions in tmux
+type TmuxMultiplexer struct{}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+func (t *TmuxMultiplexer) Name() string {
+	return Tmux
+}
+
+func (t *TmuxMultiplexer) Installed() bool {
+	return installed("tmux")
+}
+
+func (t *TmuxMultiplexer) Exists(ctx context.Context, session string) bool {
+	// The = prefix requests an exact match instead of tmux's prefix matching
+	return exec.CommandContext(ctx, "tmux", "has-session", "-t", "="+session).Run() == nil
+}
+
+// CreateCommand starts a new tmux session. Variables are passed with -e
+// because an already running tmux server does not see the client's
+// environment.
+func (t *TmuxMultiplexer) CreateCommand(ctx context.Context, session string, options interfaces.PTYOptions) (*exec.Cmd, error) {
+	args := []string{"new-session", "-s", session}
+	if options.Dir != "" {
+		args = append(args, "-c", options.Dir)
+	}
+	for _, env := range options.Env {
+		args = append(args, "-e", env)
+	}
+	if options.Command != "" {
+		args = append(append(args, "--", options.Command), options.Args...)
+	}
+	return exec.CommandContext(ctx, "tmux", args...), nil
+}
+
+func (t *TmuxMultiplexer) AttachCommand(ctx context.Context, session string) *exec.Cmd {
+	return exec.CommandContext(ctx, "tmux", "attach-session", "-t", "="+session)
+}
+
+func (t *TmuxMultiplexer) List(ctx context.Context) ([]string, error) {
+	output, err := exec.CommandContext(ctx, "tmux", "list-sessions", "-F", "#{session_name}").Output()
+	if err != nil {
+		// tmux exits with an error when no server is running
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
+}
+
+func (t *TmuxMultiplexer) Kill(ctx context.Context, session string) error {
+	return exec.CommandContext(ctx, "tmux", "kill-session", "-t", "="+session).Run()
+}
+
+// PaneDirectory returns the current directory of the session's active pane
+func (t *TmuxMultiplexer) PaneDirectory(ctx context.Context, session string) (string, error) {
+	output, err := exec.CommandContext(ctx, "tmux", "display-message", "-p", "-t", "="+session+":", "#{pane_current_path}").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ============================================================================
+// INTERFACE COMPLIANCE CHECKS
+// ============================================================================
+
+var (
+	_ interfaces.Multiplexer              = (*TmuxMultiplexer)(nil)
+	_ interfaces.MultiplexerPaneInspector = (*TmuxMultiplexer)(nil)
+)
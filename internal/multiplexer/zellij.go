@@ -0,0 +1,153 @@
+package multiplexer
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+const (
+	layoutDirName     = "layouts"
+	layoutPermissions = 0600
+	layoutDirPerms    = 0700
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// ZellijMultiplexer runs sessions in zellij
+type ZellijMultiplexer struct{}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// parseZellijList extracts live session names from `zellij list-sessions`
+// output, skipping exited sessions that are only kept for resurrection
+func parseZellijList(output string) []string {
+	var sessions []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.Contains(line, "EXITED") {
+			continue
+		}
+		sessions = append(sessions, fields[0])
+	}
+	return sessions
+}
+
+var kdlEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func kdlQuote(value string) string {
+	return `"` + kdlEscaper.Replace(value) + `"`
+}
+
+// writeLayout writes a single-pane layout running the profile command, as
+// zellij has no command line option for running a program with arguments
+func writeLayout(session string, options interfaces.PTYOptions) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	dir := filepath.Join(homeDir, ".portty", layoutDirName)
+	if err := os.MkdirAll(dir, layoutDirPerms); err != nil {
+		return "", fmt.Errorf("failed to create layout directory: %w", err)
+	}
+
+	var layout strings.Builder
+	layout.WriteString("layout {\n    pane command=" + kdlQuote(options.Command))
+	if len(options.Args) > 0 {
+		layout.WriteString(" {\n        args")
+		for _, arg := range options.Args {
+			layout.WriteString(" " + kdlQuote(arg))
+		}
+		layout.WriteString("\n    }")
+	}
+	layout.WriteString("\n}\n")
+
+	path := filepath.Join(dir, session+".kdl")
+	if err := os.WriteFile(path, []byte(layout.String()), layoutPermissions); err != nil {
+		return "", fmt.Errorf("failed to write zellij layout: %w", err)
+	}
+	return path, nil
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+func (z *ZellijMultiplexer) Name() string {
+	return Zellij
+}
+
+func (z *ZellijMultiplexer) Installed() bool {
+	return installed("zellij")
+}
+
+func (z *ZellijMultiplexer) Exists(ctx context.Context, session string) bool {
+	sessions, err := z.List(ctx)
+	if err != nil {
+		return false
+	}
+	for _, name := range sessions {
+		if name == session {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateCommand starts a new zellij session. Like screen, the session
+// server inherits the directory and environment of this command.
+func (z *ZellijMultiplexer) CreateCommand(ctx context.Context, session string, options interfaces.PTYOptions) (*exec.Cmd, error) {
+	args := []string{"--session", session}
+	if options.Command != "" {
+		layout, err := writeLayout(session, options)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--layout", layout)
+	}
+	return exec.CommandContext(ctx, "zellij", args...), nil
+}
+
+func (z *ZellijMultiplexer) AttachCommand(ctx context.Context, session string) *exec.Cmd {
+	return exec.CommandContext(ctx, "zellij", "attach", session)
+}
+
+func (z *ZellijMultiplexer) List(ctx context.Context) ([]string, error) {
+	output, err := exec.CommandContext(ctx, "zellij", "list-sessions", "--no-formatting").Output()
+	if err != nil {
+		// zellij exits with an error when there are no sessions
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseZellijList(string(output)), nil
+}
+
+func (z *ZellijMultiplexer) Kill(ctx context.Context, session string) error {
+	return exec.CommandContext(ctx, "zellij", "kill-session", session).Run()
+}
+
+// ============================================================================
+// INTERFACE COMPLIANCE CHECKS
+// ============================================================================
+
+var _ interfaces.Multiplexer = (*ZellijMultiplexer)(nil)
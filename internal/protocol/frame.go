@@ -0,0 +1,183 @@
+package protocol
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+// Version is the framed protocol version spoken by this build. Clients select
+// it with the Subprotocol WebSocket subprotocol; see docs/PROTOCOL.md.
+const (
+	Version     = 1
+	Subprotocol = "portty.v1"
+)
+
+// Frame opcodes. Every WebSocket message of the framed protocol is a binary
+// message whose first byte is one of these, followed by the payload.
+const (
+	// OpData carries terminal input from the client or output to it
+	OpData byte = 0x00
+	// OpResize carries the client's terminal size (client to server only)
+	OpResize byte = 0x01
+	// OpPing asks the peer to echo the payload back in an OpPong
+	OpPing byte = 0x02
+	// OpPong answers an OpPing with its payload
+	OpPong byte = 0x03
+	// OpControl carries a JSON control message with a "type" field
+	OpControl byte = 0x04
+	// OpOutput carries terminal output with its sequence (server to client
+	// only, replacing OpData once resume has been negotiated)
+	OpOutput byte = 0x05
+	// OpAck returns credit for output the client has written to its
+	// terminal (client to server only, once flow control is negotiated)
+	OpAck byte = 0x06
+	// OpUpload carries part of a file being uploaded: the upload ID as a
+	// big-endian 32-bit integer, followed by the content (client to server
+	// only, once file-transfer is negotiated)
+	OpUpload byte = 0x07
+)
+
+const (
+	resizeCellsLength  = 4
+	resizePixelsLength = 8
+	sequenceLength     = 8
+	ackLength          = 4
+	uploadIDLength     = 4
+)
+
+var (
+	ErrEmptyFrame    = errors.New("empty frame")
+	ErrInvalidResize = errors.New("invalid resize frame")
+	ErrInvalidAck    = errors.New("invalid ack frame")
+	ErrInvalidUpload = errors.New("invalid upload frame")
+)
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// EncodeFrame builds a frame from an opcode and its payload
+func EncodeFrame(opcode byte, payload []byte) []byte {
+	frame := make([]byte, 1+len(payload))
+	frame[0] = opcode
+	copy(frame[1:], payload)
+	return frame
+}
+
+// DecodeFrame splits a frame into its opcode and payload. The payload shares
+// memory with the frame.
+func DecodeFrame(frame []byte) (byte, []byte, error) {
+	if len(frame) == 0 {
+		return 0, nil, ErrEmptyFrame
+	}
+	return frame[0], frame[1:], nil
+}
+
+// EncodeControl builds an OpControl frame holding msg as JSON
+func EncodeControl(msg interface{}) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeFrame(OpControl, data), nil
+}
+
+// EncodeOutput builds an OpOutput frame: the sequence of the first byte of
+// data as a big-endian 64-bit integer, followed by the data
+func EncodeOutput(sequence uint64, data []byte) []byte {
+	frame := make([]byte, 1+sequenceLength+len(data))
+	frame[0] = OpOutput
+	binary.BigEndian.PutUint64(frame[1:], sequence)
+	copy(frame[1+sequenceLength:], data)
+	return frame
+}
+
+// EncodeAck builds the payload of an OpAck frame: the number of output bytes
+// being acknowledged as a big-endian 32-bit integer
+func EncodeAck(n uint32) []byte {
+	payload := make([]byte, ackLength)
+	binary.BigEndian.PutUint32(payload, n)
+	return payload
+}
+
+// DecodeAck parses the payload of an OpAck frame
+func DecodeAck(payload []byte) (uint32, error) {
+	if len(payload) != ackLength {
+		return 0, fmt.Errorf("%w: payload is %d bytes", ErrInvalidAck, len(payload))
+	}
+	return binary.BigEndian.Uint32(payload), nil
+}
+
+// EncodeUpload builds an OpUpload frame carrying part of the file uploaded
+// as id
+func EncodeUpload(id uint32, data []byte) []byte {
+	frame := make([]byte, 1+uploadIDLength+len(data))
+	frame[0] = OpUpload
+	binary.BigEndian.PutUint32(frame[1:], id)
+	copy(frame[1+uploadIDLength:], data)
+	return frame
+}
+
+// DecodeUpload splits the payload of an OpUpload frame into the upload ID
+// and the content, which shares memory with the payload
+func DecodeUpload(payload []byte) (uint32, []byte, error) {
+	if len(payload) < uploadIDLength {
+		return 0, nil, fmt.Errorf("%w: payload is %d bytes", ErrInvalidUpload, len(payload))
+	}
+	return binary.BigEndian.Uint32(payload), payload[uploadIDLength:], nil
+}
+
+// UploadChunkSize returns the largest content an OpUpload frame can carry
+// when messages are limited to maxMessageSize bytes
+func UploadChunkSize(maxMessageSize int64) int {
+	return int(maxMessageSize) - 1 - uploadIDLength
+}
+
+// EncodeResize builds the payload of an OpResize frame: columns and rows as
+// big-endian 16-bit integers, followed by the pixel width and height when
+// they are known
+func EncodeResize(dimensions Dimensions) []byte {
+	length := resizeCellsLength
+	if dimensions.Width > 0 || dimensions.Height > 0 {
+		length = resizePixelsLength
+	}
+
+	payload := make([]byte, length)
+	binary.BigEndian.PutUint16(payload[0:], uint16(dimensions.Cols))
+	binary.BigEndian.PutUint16(payload[2:], uint16(dimensions.Rows))
+	if length == resizePixelsLength {
+		binary.BigEndian.PutUint16(payload[4:], uint16(dimensions.Width))
+		binary.BigEndian.PutUint16(payload[6:], uint16(dimensions.Height))
+	}
+	return payload
+}
+
+// DecodeResize parses the payload of an OpResize frame
+func DecodeResize(payload []byte) (Dimensions, error) {
+	if len(payload) != resizeCellsLength && len(payload) != resizePixelsLength {
+		return Dimensions{}, fmt.Errorf("%w: payload is %d bytes", ErrInvalidResize, len(payload))
+	}
+
+	dimensions := Dimensions{
+		Cols: int(binary.BigEndian.Uint16(payload[0:])),
+		Rows: int(binary.BigEndian.Uint16(payload[2:])),
+	}
+	if len(payload) == resizePixelsLength {
+		dimensions.Width = int(binary.BigEndian.Uint16(payload[4:]))
+		dimensions.Height = int(binary.BigEndian.Uint16(payload[6:]))
+	}
+	if dimensions.Cols == 0 || dimensions.Rows == 0 {
+		return Dimensions{}, fmt.Errorf("%w: zero columns or rows", ErrInvalidResize)
+	}
+	return dimensions, nil
+}
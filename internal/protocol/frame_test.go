@@ -0,0 +1,198 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// The expected bytes below are the layouts documented in docs/PROTOCOL.md;
+// a change that breaks one of these breaks every deployed client.
+
+func TestFrameOpcodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		opcode byte
+		want   byte
+	}{
+		{"data", OpData, 0x00},
+		{"resize", OpResize, 0x01},
+		{"ping", OpPing, 0x02},
+		{"pong", OpPong, 0x03},
+		{"control", OpControl, 0x04},
+		{"output", OpOutput, 0x05},
+		{"ack", OpAck, 0x06},
+		{"upload", OpUpload, 0x07},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opcode != tt.want {
+				t.Fatalf("opcode is 0x%02x, want 0x%02x", tt.opcode, tt.want)
+			}
+
+			payload := []byte("payload")
+			frame := EncodeFrame(tt.opcode, payload)
+			if want := append([]byte{tt.want}, payload...); !bytes.Equal(frame, want) {
+				t.Fatalf("frame is %x, want %x", frame, want)
+			}
+
+			opcode, got, err := DecodeFrame(frame)
+			if err != nil {
+				t.Fatalf("decoding failed: %v", err)
+			}
+			if opcode != tt.want || !bytes.Equal(got, payload) {
+				t.Fatalf("decoded 0x%02x %q, want 0x%02x %q", opcode, got, tt.want, payload)
+			}
+		})
+	}
+}
+
+func TestDecodeFrameEmpty(t *testing.T) {
+	for _, frame := range [][]byte{nil, {}} {
+		if _, _, err := DecodeFrame(frame); !errors.Is(err, ErrEmptyFrame) {
+			t.Fatalf("decoding %v returned %v, want %v", frame, err, ErrEmptyFrame)
+		}
+	}
+
+	opcode, payload, err := DecodeFrame([]byte{OpPing})
+	if err != nil || opcode != OpPing || len(payload) != 0 {
+		t.Fatalf("decoding a bare opcode returned 0x%02x %q %v", opcode, payload, err)
+	}
+}
+
+func TestEncodeControl(t *testing.T) {
+	frame, err := EncodeControl(Message{Type: TypeKeepalive})
+	if err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	if want := []byte("\x04{\"type\":\"keepalive\"}"); !bytes.Equal(frame, want) {
+		t.Fatalf("frame is %q, want %q", frame, want)
+	}
+}
+
+func TestEncodeOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence uint64
+		data     []byte
+		want     []byte
+	}{
+		{"zero", 0, []byte("hi"), []byte("\x05\x00\x00\x00\x00\x00\x00\x00\x00hi")},
+		{"big endian", 0x0102030405060708, []byte("x"), []byte("\x05\x01\x02\x03\x04\x05\x06\x07\x08x")},
+		{"no data", 1 << 32, nil, []byte("\x05\x00\x00\x00\x01\x00\x00\x00\x00")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EncodeOutput(tt.sequence, tt.data); !bytes.Equal(got, tt.want) {
+				t.Fatalf("frame is %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAck(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    uint32
+		err     error
+	}{
+		{"small", []byte{0x00, 0x00, 0x01, 0x00}, 256, nil},
+		{"big endian", []byte{0x01, 0x02, 0x03, 0x04}, 0x01020304, nil},
+		{"max", []byte{0xff, 0xff, 0xff, 0xff}, 0xffffffff, nil},
+		{"empty", nil, 0, ErrInvalidAck},
+		{"short", []byte{0x00, 0x01, 0x00}, 0, ErrInvalidAck},
+		{"long", []byte{0x00, 0x00, 0x00, 0x01, 0x00}, 0, ErrInvalidAck},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeAck(tt.payload)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error is %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("decoded %d, want %d", got, tt.want)
+			}
+			if tt.err == nil && !bytes.Equal(EncodeAck(tt.want), tt.payload) {
+				t.Fatalf("encoding %d gives %x, want %x", tt.want, EncodeAck(tt.want), tt.payload)
+			}
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		id      uint32
+		content []byte
+		err     error
+	}{
+		{"content", []byte("\x00\x00\x00\x07abc"), 7, []byte("abc"), nil},
+		{"big endian", []byte("\x01\x02\x03\x04z"), 0x01020304, []byte("z"), nil},
+		{"no content", []byte("\x00\x00\x00\x01"), 1, []byte{}, nil},
+		{"empty", nil, 0, nil, ErrInvalidUpload},
+		{"short", []byte("\x00\x00\x01"), 0, nil, ErrInvalidUpload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, content, err := DecodeUpload(tt.payload)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error is %v, want %v", err, tt.err)
+			}
+			if id != tt.id || !bytes.Equal(content, tt.content) {
+				t.Fatalf("decoded %d %q, want %d %q", id, content, tt.id, tt.content)
+			}
+			if tt.err != nil {
+				return
+			}
+			frame := EncodeUpload(tt.id, tt.content)
+			if want := append([]byte{OpUpload}, tt.payload...); !bytes.Equal(frame, want) {
+				t.Fatalf("encoding gives %x, want %x", frame, want)
+			}
+		})
+	}
+
+	if got := UploadChunkSize(1024); got != 1019 {
+		t.Fatalf("chunk size for 1024 byte messages is %d, want 1019", got)
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    []byte
+		dimensions Dimensions
+		err        error
+	}{
+		{"cells", []byte{0x00, 0x50, 0x00, 0x18}, Dimensions{Cols: 80, Rows: 24}, nil},
+		{"big endian", []byte{0x01, 0x2c, 0x00, 0x64}, Dimensions{Cols: 300, Rows: 100}, nil},
+		{"pixels", []byte{0x00, 0x50, 0x00, 0x18, 0x02, 0x80, 0x01, 0xe0},
+			Dimensions{Cols: 80, Rows: 24, Width: 640, Height: 480}, nil},
+		{"empty", nil, Dimensions{}, ErrInvalidResize},
+		{"short", []byte{0x00, 0x50, 0x00}, Dimensions{}, ErrInvalidResize},
+		{"between", []byte{0x00, 0x50, 0x00, 0x18, 0x02, 0x80}, Dimensions{}, ErrInvalidResize},
+		{"long", []byte{0x00, 0x50, 0x00, 0x18, 0x02, 0x80, 0x01, 0xe0, 0x00}, Dimensions{}, ErrInvalidResize},
+		{"zero cols", []byte{0x00, 0x00, 0x00, 0x18}, Dimensions{}, ErrInvalidResize},
+		{"zero rows", []byte{0x00, 0x50, 0x00, 0x00}, Dimensions{}, ErrInvalidResize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeResize(tt.payload)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error is %v, want %v", err, tt.err)
+			}
+			if got != tt.dimensions {
+				t.Fatalf("decoded %+v, want %+v", got, tt.dimensions)
+			}
+			if tt.err == nil && !bytes.Equal(EncodeResize(tt.dimensions), tt.payload) {
+				t.Fatalf("encoding %+v gives %x, want %x", tt.dimensions, EncodeResize(tt.dimensions), tt.payload)
+			}
+		})
+	}
+}
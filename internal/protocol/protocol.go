@@ -0,0 +1,398 @@
+package protocol
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+const (
+	TypeResize      = "resize"
+	TypeKeepalive   = "keepalive"
+	TypeTmux        = "tmux"
+	TypeTmuxInput   = "tmux-input"
+	TypeTmuxCommand = "tmux-command"
+	TypeExited      = "exited"
+	TypeSession     = "session"
+	TypeProcess     = "process"
+	TypeSignal      = "signal"
+	TypeJob         = "job"
+	TypeHello       = "hello"
+	TypeLatency     = "latency"
+
+	TypeBroadcast       = "broadcast"
+	TypeBroadcastOptOut = "broadcast-opt-out"
+	TypeBroadcastStatus = "broadcast-status"
+	TypeBroadcastState  = "broadcast-state"
+
+	TypeUpload       = "upload"
+	TypeUploadCancel = "upload-cancel"
+	TypeUploadStatus = "upload-status"
+)
+
+// Optional features negotiated in the hello exchange. A feature is only used
+// when both sides list it.
+const (
+	FeatureCompression  = "compression"
+	FeatureResume       = "resume"
+	FeatureFileTransfer = "file-transfer"
+	FeatureClipboard    = "clipboard"
+	FeatureFlowControl  = "flow-control"
+	FeatureLatency      = "latency"
+	FeatureTextOutput   = "text-output"
+)
+
+// BroadcastAll selects every other session open when broadcasting starts
+const BroadcastAll = "*"
+
+const (
+	SignalTargetForeground = "foreground"
+	SignalTargetSession    = "session"
+)
+
+// Signals lists the signals clients may deliver to a session
+var Signals = []string{"SIGINT", "SIGTERM", "SIGHUP", "SIGKILL", "SIGSTOP", "SIGCONT", "SIGQUIT"}
+
+const (
+	ExitActionClose   = "close"
+	ExitActionKeep    = "keep"
+	ExitActionRespawn = "respawn"
+)
+
+// What to do when an uploaded file has the name of an existing file
+const (
+	ConflictRename    = "rename"
+	ConflictOverwrite = "overwrite"
+	ConflictFail      = "fail"
+)
+
+const (
+	UploadStarted   = "started"
+	UploadProgress  = "progress"
+	UploadDone      = "done"
+	UploadFailed    = "failed"
+	UploadCancelled = "cancelled"
+)
+
+const (
+	TmuxEventWindowAdd            = "window-add"
+	TmuxEventWindowClose          = "window-close"
+	TmuxEventWindowRenamed        = "window-renamed"
+	TmuxEventWindowPaneChanged    = "window-pane-changed"
+	TmuxEventLayoutChange         = "layout-change"
+	TmuxEventOutput               = "output"
+	TmuxEventSessionChanged       = "session-changed"
+	TmuxEventSessionRenamed       = "session-renamed"
+	TmuxEventSessionWindowChanged = "session-window-changed"
+	TmuxEventSessionsChanged      = "sessions-changed"
+	TmuxEventPaneModeChanged      = "pane-mode-changed"
+	TmuxEventError                = "error"
+	TmuxEventExit                 = "exit"
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// Message is the common envelope used to identify control messages
+type Message struct {
+	Type string `json:"type"`
+}
+
+// ResizeMessage requests a terminal size change
+type ResizeMessage struct {
+	Type       string     `json:"type"`
+	Dimensions Dimensions `json:"dimensions"`
+}
+
+// Dimensions describes a terminal size in character cells and, optionally,
+// in pixels
+type Dimensions struct {
+	Cols   int `json:"cols"`
+	Rows   int `json:"rows"`
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// HelloMessage opens a connection in both directions. The client sends the
+// protocol version it speaks, the features it supports and its terminal size;
+// the server answers with the features both sides support, its release and
+// the session the client is attached to.
+type HelloMessage struct {
+	Type          string            `json:"type"`
+	Version       int               `json:"version"`
+	Features      []string          `json:"features"`
+	Client        string            `json:"client,omitempty"`
+	Dimensions    *Dimensions       `json:"dimensions,omitempty"`
+	Resume        *ResumeRequest    `json:"resume,omitempty"`
+	ServerVersion string            `json:"server_version,omitempty"`
+	Session       *HelloSession     `json:"session,omitempty"`
+	Terminal      *TerminalSettings `json:"terminal,omitempty"`
+	ResumeToken   string            `json:"resume_token,omitempty"`
+	Resumed       bool              `json:"resumed,omitempty"`
+}
+
+// ResumeRequest asks to reattach to the session a resume token was issued
+// for, receiving only the output after the last sequence the client saw
+type ResumeRequest struct {
+	Token    string `json:"token"`
+	Sequence uint64 `json:"sequence"`
+}
+
+// HelloSession identifies the session a client is attached to
+type HelloSession struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// TerminalSettings describes how the terminal of a session was started
+type TerminalSettings struct {
+	Term        string `json:"term,omitempty"`
+	ColorTerm   string `json:"colorterm,omitempty"`
+	Cols        int    `json:"cols,omitempty"`
+	Rows        int    `json:"rows,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+	Multiplexer string `json:"multiplexer,omitempty"`
+	ExitAction  string `json:"exit_action,omitempty"`
+}
+
+// SessionMessage tells a client which session it is attached to
+type SessionMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ProcessMessage describes the process currently in the foreground of a
+// session and where it is running
+type ProcessMessage struct {
+	Type    string   `json:"type"`
+	PID     int      `json:"pid"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Cwd     string   `json:"cwd,omitempty"`
+	Title   string   `json:"title"`
+}
+
+// LatencyMessage tells a client the round trip time the server measured on
+// its connection, and how much it varies, in milliseconds
+type LatencyMessage struct {
+	Type   string  `json:"type"`
+	RTT    float64 `json:"rtt_ms"`
+	Jitter float64 `json:"jitter_ms"`
+}
+
+// SignalMessage asks the server to deliver a signal to the foreground process
+// group of a session or to every process in it
+type SignalMessage struct {
+	Type   string `json:"type"`
+	Signal string `json:"signal"`
+	Target string `json:"target,omitempty"`
+}
+
+// BroadcastMessage selects the sessions that receive a copy of the client's
+// input; an empty list stops broadcasting
+type BroadcastMessage struct {
+	Type     string   `json:"type"`
+	Sessions []string `json:"sessions"`
+}
+
+// BroadcastOptOutMessage excludes the client's session from other clients'
+// broadcasts, or includes it again
+type BroadcastOptOutMessage struct {
+	Type   string `json:"type"`
+	OptOut bool   `json:"opt_out"`
+}
+
+// BroadcastFailure reports a session that could not receive broadcast input
+type BroadcastFailure struct {
+	Session string `json:"session"`
+	Error   string `json:"error"`
+}
+
+// BroadcastStatusMessage tells a broadcasting client where its input goes
+type BroadcastStatusMessage struct {
+	Type     string             `json:"type"`
+	Targets  []string           `json:"targets"`
+	Skipped  []string           `json:"skipped,omitempty"`
+	Failures []BroadcastFailure `json:"failures,omitempty"`
+}
+
+// BroadcastStateMessage tells the clients of a session which sessions are
+// broadcasting into it and whether it has opted out
+type BroadcastStateMessage struct {
+	Type    string   `json:"type"`
+	Sources []string `json:"sources"`
+	OptOut  bool     `json:"opt_out"`
+}
+
+// JobMessage tells a client which job it is viewing. Job viewers are
+// read-only: their input is ignored.
+type JobMessage struct {
+	Type    string   `json:"type"`
+	ID      string   `json:"id"`
+	Command []string `json:"command"`
+	State   string   `json:"state"`
+}
+
+// UploadMessage starts uploading a file into the working directory of the
+// session's foreground process. The content follows in OpUpload frames
+// carrying the same ID.
+type UploadMessage struct {
+	Type     string `json:"type"`
+	ID       uint32 `json:"id"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// UploadCancelMessage abandons an upload, removing what was written so far
+type UploadCancelMessage struct {
+	Type string `json:"type"`
+	ID   uint32 `json:"id"`
+}
+
+// UploadStatusMessage reports the progress of an upload. ChunkSize is the
+// largest content an OpUpload frame may carry and is sent when the upload
+// starts; Path is where the file was written once it is done.
+type UploadStatusMessage struct {
+	Type      string `json:"type"`
+	ID        uint32 `json:"id"`
+	State     string `json:"state"`
+	Received  int64  `json:"received"`
+	Size      int64  `json:"size"`
+	ChunkSize int    `json:"chunk_size,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ExitedMessage reports that the process behind a session has terminated and
+// what the server will do next
+type ExitedMessage struct {
+	Type         string `json:"type"`
+	Code         int    `json:"code"`
+	Signal       int    `json:"signal,omitempty"`
+	SignalName   string `json:"signal_name,omitempty"`
+	Action       string `json:"action"`
+	RespawnDelay int64  `json:"respawn_delay_ms,omitempty"`
+}
+
+// TmuxEvent is a structured notification translated from tmux control mode
+type TmuxEvent struct {
+	Type    string      `json:"type"`
+	Event   string      `json:"event"`
+	Session string      `json:"session,omitempty"`
+	Window  string      `json:"window,omitempty"`
+	Pane    string      `json:"pane,omitempty"`
+	Name    string      `json:"name,omitempty"`
+	Active  bool        `json:"active,omitempty"`
+	Layout  *TmuxLayout `json:"layout,omitempty"`
+	Data    []byte      `json:"data,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// TmuxLayout is a parsed tmux window layout tree
+type TmuxLayout struct {
+	Width    int          `json:"width"`
+	Height   int          `json:"height"`
+	X        int          `json:"x"`
+	Y        int          `json:"y"`
+	Pane     string       `json:"pane,omitempty"`
+	Split    string       `json:"split,omitempty"`
+	Children []TmuxLayout `json:"children,omitempty"`
+}
+
+// TmuxInputMessage carries input destined for a specific tmux pane
+type TmuxInputMessage struct {
+	Type string `json:"type"`
+	Pane string `json:"pane"`
+	Data string `json:"data"`
+}
+
+// TmuxCommandMessage requests a whitelisted tmux window or pane operation
+type TmuxCommandMessage struct {
+	Type      string `json:"type"`
+	Command   string `json:"command"`
+	Target    string `json:"target,omitempty"`
+	Direction string `json:"direction,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// NegotiateFeatures returns the features in supported that were offered by
+// the peer
+func NegotiateFeatures(offered, supported []string) []string {
+	features := []string{}
+	for _, feature := range supported {
+		if HasFeature(offered, feature) {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// HasFeature reports whether feature is in features
+func HasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeSignal converts a signal name such as "int" or "SIGINT" to its
+// canonical form, reporting whether it is one of the supported Signals
+func NormalizeSignal(name string) (string, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for _, signal := range Signals {
+		if signal == name {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// IsClientControl reports whether msgType names a control message clients
+// send to the server. In the legacy protocol, input that parses as JSON with
+// any other type is treated as keystrokes.
+func IsClientControl(msgType string) bool {
+	switch msgType {
+	case TypeResize, TypeKeepalive, TypeSignal, TypeBroadcast, TypeBroadcastOptOut,
+		TypeTmuxInput, TypeTmuxCommand:
+		return true
+	}
+	return false
+}
+
+// DecodeType returns the message type of a JSON control message
+func DecodeType(data []byte) (string, bool) {
+	if len(data) == 0 || data[0] != '{' {
+		return "", false
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+		return "", false
+	}
+
+	return msg.Type, true
+}
+
+// Encode serializes a protocol message for transmission
+func Encode(msg interface{}) ([]byte, error) {
+	return json.Marshal(msg)
+}
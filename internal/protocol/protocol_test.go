@@ -0,0 +1,66 @@
+package protocol
+
+import "testing"
+
+func TestDecodeType(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+		ok   bool
+	}{
+		{"resize", `{"type":"resize","dimensions":{"cols":80,"rows":24}}`, TypeResize, true},
+		{"hello", `{"type":"hello","version":1}`, TypeHello, true},
+		{"unknown type", `{"type":"whatever"}`, "whatever", true},
+		{"empty", ``, "", false},
+		{"leading space", ` {"type":"resize"}`, "", false},
+		{"not an object", `["type"]`, "", false},
+		{"no type", `{"dimensions":{}}`, "", false},
+		{"empty type", `{"type":""}`, "", false},
+		{"type not a string", `{"type":1}`, "", false},
+		{"truncated", `{"type":"resize"`, "", false},
+		{"keystrokes", "ls -la\r", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DecodeType([]byte(tt.data))
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("decoded %q %v, want %q %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIsClientControl(t *testing.T) {
+	tests := []struct {
+		msgType string
+		want    bool
+	}{
+		{TypeResize, true},
+		{TypeKeepalive, true},
+		{TypeSignal, true},
+		{TypeBroadcast, true},
+		{TypeBroadcastOptOut, true},
+		{TypeTmuxInput, true},
+		{TypeTmuxCommand, true},
+		{TypeHello, false},
+		{TypeUpload, false},
+		{TypeUploadCancel, false},
+		{TypeExited, false},
+		{TypeSession, false},
+		{TypeProcess, false},
+		{TypeBroadcastStatus, false},
+		{TypeLatency, false},
+		{"", false},
+		{"RESIZE", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msgType, func(t *testing.T) {
+			if got := IsClientControl(tt.msgType); got != tt.want {
+				t.Fatalf("IsClientControl(%q) is %v, want %v", tt.msgType, got, tt.want)
+			}
+		})
+	}
+}
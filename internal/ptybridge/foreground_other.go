@@ -0,0 +1,12 @@
+//go:build !darwin && !freebsd && !linux && !netbsd && !openbsd
+
+package ptybridge
+
+import (
+	"errors"
+	"os"
+)
+
+func foregroundProcessGroup(f *os.File) (int, error) {
+	return 0, errors.New("foreground process tracking is not supported on this platform")
+}
@@ -0,0 +1,30 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+
+package ptybridge
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// foregroundProcessGroup returns the foreground process group of the
+// terminal, the equivalent of tcgetpgrp(3) on the master side
+func foregroundProcessGroup(f *os.File) (int, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var pgrp int32
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	}); err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
@@ -11,12 +11,18 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/PiTZE/PorTTY/internal/config"
 	"github.com/PiTZE/PorTTY/internal/interfaces"
 	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/multiplexer"
+	"github.com/PiTZE/PorTTY/internal/protocol"
 	"github.com/creack/pty"
 )
 
@@ -28,6 +34,18 @@ var cfg = config.Default
 
 var sessionMutex sync.Mutex
 
+// teardownPollInterval is how often Close checks whether the processes in a
+// terminal session have exited after being signalled
+const teardownPollInterval = 50 * time.Millisecond
+
+// teardownScanInterval is how often teardown scans /proc for processes that a
+// null signal can't tell apart from zombies nobody reaps
+const teardownScanInterval = time.Second
+
+// exitDrainTimeout bounds how long reads wait for remaining output once the
+// child has exited, since background processes may keep the terminal open
+const exitDrainTimeout = 100 * time.Millisecond
+
 // ============================================================================
 // TYPE DEFINITIONS
 // ============================================================================
@@ -35,30 +53,166 @@ var sessionMutex sync.Mutex
 type PTYBridge struct {
 	cmd         *exec.Cmd
 	pty         *os.File
-	done        chan struct{}
+	closeOnce   sync.Once
+	closing     chan struct{}
+	torndown    chan struct{}
+	closeErr    error
+	exited      chan struct{}
+	exitCode    int
+	exitSignal  os.Signal
+	events      chan []byte
+	processMu   sync.Mutex
+	foreground  foregroundProcess
 	sessionName string
+	multiplexer string
+	mux         interfaces.Multiplexer
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
-type ResizeMessage struct {
-	Type       string     `json:"type"`
-	Dimensions Dimensions `json:"dimensions"`
-}
-
-type Dimensions struct {
-	Cols int `json:"cols"`
-	Rows int `json:"rows"`
+type foregroundProcess struct {
+	pid  int
+	args []string
+	cwd  string
 }
 
 // ============================================================================
 // UTILITY FUNCTIONS
 // ============================================================================
 
-func checkSessionExists(sessionName string) bool {
-	cmd := exec.Command("tmux", "has-session", "-t", sessionName)
-	err := cmd.Run()
-	return err == nil
+func readProcessCwd(pid int) string {
+	cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	if err != nil {
+		return ""
+	}
+	return cwd
+}
+
+func readProcessStrings(pid int, name string) []string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/%s", pid, name))
+	if err != nil {
+		return nil
+	}
+
+	var values []string
+	for _, value := range strings.Split(string(data), "\x00") {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func initialSize(options interfaces.PTYOptions) *pty.Winsize {
+	size := &pty.Winsize{
+		Rows: uint16(cfg.Terminal.DefaultRows),
+		Cols: uint16(cfg.Terminal.DefaultCols),
+	}
+	if options.Rows > 0 && options.Cols > 0 {
+		size.Rows = uint16(options.Rows)
+		size.Cols = uint16(options.Cols)
+		size.X = uint16(options.Width)
+		size.Y = uint16(options.Height)
+	}
+	return size
+}
+
+// terminalEnv returns the TERM and COLORTERM settings for a launch
+func terminalEnv(options interfaces.PTYOptions) []string {
+	term, colorTerm := cfg.Terminal.DefaultTerm, cfg.Terminal.DefaultColor
+	if options.Term != "" {
+		term = options.Term
+	}
+	if options.ColorTerm != "" {
+		colorTerm = options.ColorTerm
+	}
+	return []string{"TERM=" + term, "COLORTERM=" + colorTerm}
+}
+
+func commandName(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(filepath.Base(args[0]), "-")
+}
+
+// processTitle formats a short description such as "vim ~/src/app"
+func processTitle(args []string, cwd string) string {
+	if homeDir, err := os.UserHomeDir(); err == nil && homeDir != "/" {
+		if cwd == homeDir {
+			cwd = "~"
+		} else if strings.HasPrefix(cwd, homeDir+"/") {
+			cwd = "~" + strings.TrimPrefix(cwd, homeDir)
+		}
+	}
+	return strings.TrimSpace(commandName(args) + " " + cwd)
+}
+
+// sessionProcessGroups lists the process groups belonging to the terminal
+// session led by sid, always including the session leader's own group
+func sessionProcessGroups(sid int) []int {
+	return withLeaderGroup(sid, liveSessionGroups(sid))
+}
+
+// withLeaderGroup adds the group of the session leader sid to groups
+func withLeaderGroup(sid int, live []int) []int {
+	groups := []int{sid}
+	for _, pgrp := range live {
+		if pgrp != sid {
+			groups = append(groups, pgrp)
+		}
+	}
+	return groups
+}
+
+// liveSessionGroups lists the process groups that still have running (not
+// zombie) members in the terminal session led by sid. Processes outlive the
+// session leader, so this also finds background jobs left behind by a shell
+// that has exited.
+func liveSessionGroups(sid int) []int {
+	var groups []int
+	seen := map[int]bool{}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			continue
+		}
+
+		// Fields after the parenthesised command name: state ppid pgrp session
+		end := strings.LastIndexByte(string(data), ')')
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(string(data[end+1:]))
+		if len(fields) < 4 {
+			continue
+		}
+		if fields[0] == "Z" {
+			continue
+		}
+		pgrp, _ := strconv.Atoi(fields[2])
+		session, _ := strconv.Atoi(fields[3])
+		if session == sid && pgrp > 0 && !seen[pgrp] {
+			seen[pgrp] = true
+			groups = append(groups, pgrp)
+		}
+	}
+
+	return groups
+}
+
+func (f foregroundProcess) equal(other foregroundProcess) bool {
+	return f.pid == other.pid && f.cwd == other.cwd && strings.Join(f.args, "\x00") == strings.Join(other.args, "\x00")
 }
 
 // ============================================================================
@@ -66,6 +220,11 @@ func checkSessionExists(sessionName string) bool {
 // ============================================================================
 
 func New(parentCtx context.Context) (*PTYBridge, error) {
+	return NewWithOptions(parentCtx, interfaces.PTYOptions{})
+}
+
+// NewWithOptions starts a shell or tmux client using the given launch options
+func NewWithOptions(parentCtx context.Context, options interfaces.PTYOptions) (*PTYBridge, error) {
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
 
@@ -76,64 +235,93 @@ func New(parentCtx context.Context) (*PTYBridge, error) {
 	var err error
 	var sessionName string
 
-	if cfg.Server.UseTmux {
-		sessionExists := checkSessionExists(cfg.Server.SessionName)
-		sessionName = cfg.Server.SessionName
-
-		if sessionExists {
-			logger.PTYBridgeLogger.Info("Attaching to existing tmux session", logger.String("session", cfg.Server.SessionName))
-			cmd = exec.CommandContext(ctx, "tmux", "attach-session", "-t", cfg.Server.SessionName)
-		} else {
-			logger.PTYBridgeLogger.Info("Creating new tmux session", logger.String("session", cfg.Server.SessionName))
+	// Profiles choose their own program and whether a multiplexer wraps it
+	multiplexerName := cfg.Server.Multiplexer
+	program, programArgs := cfg.Terminal.DefaultShell, []string(nil)
+	if options.Command != "" {
+		multiplexerName = options.Multiplexer
+		program, programArgs = options.Command, options.Args
+	}
 
-			killCmd := exec.CommandContext(ctx, "tmux", "kill-session", "-t", cfg.Server.SessionName)
-			killCmd.Run()
+	var mux interfaces.Multiplexer
+	if multiplexer.Enabled(multiplexerName) {
+		if mux, err = multiplexer.New(multiplexerName); err != nil {
+			cancel()
+			return nil, err
+		}
+		sessionName = multiplexer.SessionName(options.Profile)
 
-			cmd = exec.CommandContext(ctx, "tmux", "new-session", "-s", cfg.Server.SessionName)
+		if mux.Exists(ctx, sessionName) {
+			logger.PTYBridgeLogger.Info("Attaching to existing multiplexer session",
+				logger.String("multiplexer", mux.Name()),
+				logger.String("session", sessionName))
+			cmd = mux.AttachCommand(ctx, sessionName)
+		} else {
+			logger.PTYBridgeLogger.Info("Creating new multiplexer session",
+				logger.String("multiplexer", mux.Name()),
+				logger.String("session", sessionName))
+			if cmd, err = mux.CreateCommand(ctx, sessionName, options); err != nil {
+				cancel()
+				return nil, fmt.Errorf("failed to create %s session: %w", mux.Name(), err)
+			}
+			cmd.Dir = options.Dir
 		}
 	} else {
-		logger.PTYBridgeLogger.Info("Starting direct shell session", logger.String("shell", cfg.Terminal.DefaultShell))
-		cmd = exec.CommandContext(ctx, cfg.Terminal.DefaultShell)
+		logger.PTYBridgeLogger.Info("Starting direct shell session",
+			logger.String("shell", program),
+			logger.String("profile", options.Profile))
+		cmd = exec.CommandContext(ctx, program, programArgs...)
+		if options.Command == "" && cfg.Terminal.LoginShell {
+			// A leading dash in argv0 asks the shell to act as a login shell
+			cmd.Args[0] = "-" + filepath.Base(program)
+		}
+		cmd.Dir = options.Dir
 		sessionName = "DirectShell"
 	}
 
-	cmd.Env = append(os.Environ(),
-		"TERM="+cfg.Terminal.DefaultTerm,
-		"COLORTERM="+cfg.Terminal.DefaultColor,
-	)
+	cmd.Env = append(os.Environ(), terminalEnv(options)...)
+	cmd.Env = append(cmd.Env, options.Env...)
 
-	ptmx, err = pty.Start(cmd)
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to start pty: %w", err)
+	// Close tears the terminal session down in stages, so cancelling the
+	// context must not SIGKILL the child straight away
+	if cmd.Cancel != nil {
+		cmd.Cancel = func() error { return nil }
 	}
 
-	if err := pty.Setsize(ptmx, &pty.Winsize{
-		Rows: uint16(cfg.Terminal.DefaultRows),
-		Cols: uint16(cfg.Terminal.DefaultCols),
-	}); err != nil {
-		ptmx.Close()
-		cmd.Process.Kill()
+	// Size the terminal before the child starts so programs launched from
+	// shell startup files see the client's real dimensions
+	ptmx, err = pty.StartWithSize(cmd, initialSize(options))
+	if err != nil {
 		cancel()
-		return nil, fmt.Errorf("failed to set initial terminal size: %w", err)
+		return nil, fmt.Errorf("failed to start pty: %w", err)
 	}
 
-	if cfg.Server.UseTmux {
-		logger.PTYBridgeLogger.Info("Connected to tmux session", logger.String("session", cfg.Server.SessionName))
+	if mux != nil {
+		logger.PTYBridgeLogger.Info("Connected to multiplexer session",
+			logger.String("multiplexer", mux.Name()),
+			logger.String("session", sessionName))
 	} else {
-		logger.PTYBridgeLogger.Info("Connected to direct shell", logger.String("shell", cfg.Terminal.DefaultShell))
+		logger.PTYBridgeLogger.Info("Connected to direct shell", logger.String("shell", program))
 	}
 
 	bridge := &PTYBridge{
 		cmd:         cmd,
 		pty:         ptmx,
-		done:        make(chan struct{}),
+		closing:     make(chan struct{}),
+		torndown:    make(chan struct{}),
+		exited:      make(chan struct{}),
+		exitCode:    -1,
+		events:      make(chan []byte, 16),
 		sessionName: sessionName,
+		multiplexer: multiplexerName,
+		mux:         mux,
 		ctx:         ctx,
 		cancel:      cancel,
 	}
 
+	go bridge.wait()
 	go bridge.monitorContext()
+	go bridge.trackForeground()
 
 	return bridge, nil
 }
@@ -144,6 +332,198 @@ func (p *PTYBridge) monitorContext() {
 	p.Close()
 }
 
+// wait reaps the child process and records how it terminated
+func (p *PTYBridge) wait() {
+	p.cmd.Wait()
+
+	if state := p.cmd.ProcessState; state != nil {
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			p.exitSignal = status.Signal()
+			p.exitCode = 128 + int(status.Signal())
+		} else {
+			p.exitCode = state.ExitCode()
+		}
+	}
+
+	logger.PTYBridgeLogger.Info("Shell process exited",
+		logger.String("session", p.sessionName),
+		logger.Int("code", p.exitCode))
+	close(p.exited)
+}
+
+// Exited is closed once the child process has been reaped
+func (p *PTYBridge) Exited() <-chan struct{} {
+	return p.exited
+}
+
+// ExitStatus returns the exit code and terminating signal of the child
+// process; it is only meaningful after Exited is closed
+func (p *PTYBridge) ExitStatus() (int, os.Signal) {
+	select {
+	case <-p.exited:
+		return p.exitCode, p.exitSignal
+	default:
+		return -1, nil
+	}
+}
+
+// inspectForeground reads the foreground process group of the terminal and
+// describes its leader, falling back to the shell itself
+func (p *PTYBridge) inspectForeground() foregroundProcess {
+	pid := p.cmd.Process.Pid
+	if pgrp, err := foregroundProcessGroup(p.pty); err == nil && pgrp > 0 {
+		pid = pgrp
+	}
+
+	process := foregroundProcess{
+		pid:  pid,
+		args: readProcessStrings(pid, "cmdline"),
+		cwd:  readProcessCwd(pid),
+	}
+	if process.args == nil && pid == p.cmd.Process.Pid {
+		process.args = p.cmd.Args
+	}
+	return process
+}
+
+// trackForeground polls the foreground process and publishes a process
+// event whenever the command or its working directory changes
+func (p *PTYBridge) trackForeground() {
+	interval := cfg.Terminal.ProcessPollInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		process := p.inspectForeground()
+
+		p.processMu.Lock()
+		changed := !process.equal(p.foreground)
+		if changed {
+			p.foreground = process
+		}
+		p.processMu.Unlock()
+
+		if changed {
+			p.emitProcess(process)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.exited:
+			return
+		case <-p.closing:
+			return
+		}
+	}
+}
+
+func (p *PTYBridge) emitProcess(process foregroundProcess) {
+	data, err := protocol.Encode(protocol.ProcessMessage{
+		Type:    protocol.TypeProcess,
+		PID:     process.pid,
+		Command: commandName(process.args),
+		Args:    process.args,
+		Cwd:     process.cwd,
+		Title:   processTitle(process.args, process.cwd),
+	})
+	if err != nil {
+		return
+	}
+
+	select {
+	case p.events <- data:
+	case <-p.closing:
+	case <-p.exited:
+	}
+}
+
+// Signal delivers a signal to the foreground process group of the terminal or,
+// with the session target, to every process group in the shell's session
+func (p *PTYBridge) Signal(signal, target string) error {
+	select {
+	case <-p.exited:
+		return fmt.Errorf("process has already exited")
+	default:
+	}
+
+	shellPid := p.cmd.Process.Pid
+	var groups []int
+	switch target {
+	case protocol.SignalTargetSession:
+		groups = sessionProcessGroups(shellPid)
+	case "", protocol.SignalTargetForeground:
+		pgrp, err := foregroundProcessGroup(p.pty)
+		if err != nil || pgrp <= 0 {
+			pgrp = shellPid
+		}
+		groups = []int{pgrp}
+	default:
+		return fmt.Errorf("unknown signal target %q", target)
+	}
+
+	logger.PTYBridgeLogger.Info("Sending signal",
+		logger.String("signal", signal),
+		logger.String("target", target),
+		logger.Int("groups", len(groups)))
+	if err := signalProcessGroups(groups, signal); err != nil {
+		return err
+	}
+
+	// Stopped jobs only act on a signal once continued, as the shell does on hangup
+	if target == protocol.SignalTargetSession && signal != "SIGSTOP" && signal != "SIGCONT" {
+		return signalProcessGroups(groups, "SIGCONT")
+	}
+	return nil
+}
+
+// Events returns process change notifications for the session
+func (p *PTYBridge) Events() <-chan []byte {
+	return p.events
+}
+
+// ForegroundProcess returns the most recently observed foreground process
+func (p *PTYBridge) ForegroundProcess() (int, []string, string) {
+	p.processMu.Lock()
+	defer p.processMu.Unlock()
+	return p.foreground.pid, p.foreground.args, p.foreground.cwd
+}
+
+// WorkingDirectory returns the current directory of the foreground process.
+// Behind a multiplexer that process is the multiplexer's client, so the
+// multiplexer is asked about its active pane instead, and the directory is
+// unknown if it can't tell.
+func (p *PTYBridge) WorkingDirectory() string {
+	if p.mux != nil {
+		inspector, ok := p.mux.(interfaces.MultiplexerPaneInspector)
+		if !ok {
+			return ""
+		}
+		ctx, cancel := context.WithTimeout(p.ctx, cfg.Server.PTYOperationTimeout)
+		defer cancel()
+		dir, err := inspector.PaneDirectory(ctx, p.sessionName)
+		if err != nil {
+			return ""
+		}
+		return dir
+	}
+	if _, _, cwd := p.ForegroundProcess(); cwd != "" {
+		return cwd
+	}
+	return readProcessCwd(p.cmd.Process.Pid)
+}
+
+// Environment returns the environment the shell process was started with
+func (p *PTYBridge) Environment() []string {
+	if env := readProcessStrings(p.cmd.Process.Pid, "environ"); env != nil {
+		return env
+	}
+	return p.cmd.Env
+}
+
 func (p *PTYBridge) Read(ctx context.Context, b []byte) (int, error) {
 	select {
 	case <-ctx.Done():
@@ -166,7 +546,26 @@ func (p *PTYBridge) Read(ctx context.Context, b []byte) (int, error) {
 
 	select {
 	case result := <-resultChan:
+		if result.err != nil {
+			// Once the shell is gone the master reports EIO after the remaining
+			// output has been drained; surface that as a clean end of stream
+			select {
+			case <-p.exited:
+				return result.n, io.EOF
+			case <-time.After(cfg.Server.PTYOperationTimeout):
+			}
+		}
 		return result.n, result.err
+	case <-p.exited:
+		select {
+		case result := <-resultChan:
+			if result.err != nil {
+				return result.n, io.EOF
+			}
+			return result.n, nil
+		case <-time.After(exitDrainTimeout):
+			return 0, io.EOF
+		}
 	case <-ctx.Done():
 		return 0, ctx.Err()
 	case <-p.ctx.Done():
@@ -213,21 +612,24 @@ func (p *PTYBridge) ProcessInput(ctx context.Context, data []byte) error {
 	default:
 	}
 
-	var msg map[string]interface{}
-	if err := json.Unmarshal(data, &msg); err == nil {
-		if msgType, ok := msg["type"].(string); ok {
-			switch msgType {
-			case "resize":
-				var resizeMsg ResizeMessage
-				if err := json.Unmarshal(data, &resizeMsg); err == nil {
-					return p.Resize(resizeMsg.Dimensions.Rows, resizeMsg.Dimensions.Cols)
-				} else {
-					logger.PTYBridgeLogger.Error("failed to parse resize message", err)
-				}
-				return nil
-			case "keepalive":
-				return nil
+	if msgType, ok := protocol.DecodeType(data); ok {
+		switch msgType {
+		case protocol.TypeResize:
+			var resizeMsg protocol.ResizeMessage
+			if err := json.Unmarshal(data, &resizeMsg); err == nil {
+				dimensions := resizeMsg.Dimensions
+				return pty.Setsize(p.pty, &pty.Winsize{
+					Rows: uint16(dimensions.Rows),
+					Cols: uint16(dimensions.Cols),
+					X:    uint16(dimensions.Width),
+					Y:    uint16(dimensions.Height),
+				})
+			} else {
+				logger.PTYBridgeLogger.Error("failed to parse resize message", err)
 			}
+			return nil
+		case protocol.TypeKeepalive:
+			return nil
 		}
 	}
 
@@ -242,18 +644,76 @@ func (p *PTYBridge) Resize(rows, cols int) error {
 	})
 }
 
+// Close hangs up the terminal session and waits for it to go away. Every
+// process group in the session is sent SIGHUP, then SIGTERM and finally
+// SIGKILL, each after a grace period, until the child has been reaped and no
+// process is left behind; the PTY is closed last.
 func (p *PTYBridge) Close() error {
-	select {
-	case <-p.done:
-		return nil
-	default:
-		close(p.done)
+	p.closeOnce.Do(func() {
+		close(p.closing)
+
+		if multiplexer.Enabled(p.multiplexer) {
+			logger.PTYBridgeLogger.Info("Client disconnected from multiplexer session",
+				logger.String("multiplexer", p.multiplexer),
+				logger.String("session", p.sessionName))
+		} else {
+			logger.PTYBridgeLogger.Info("Client disconnected from direct shell", logger.String("session", p.sessionName))
+		}
+
+		go p.teardown()
+	})
+
+	<-p.torndown
+	return p.closeErr
+}
+
+// teardown terminates every process in the terminal session, escalating
+// through signals until they are gone, then releases the PTY
+func (p *PTYBridge) teardown() {
+	defer close(p.torndown)
+
+	sid := p.cmd.Process.Pid
+	steps := []struct {
+		signal string
+		grace  time.Duration
+	}{
+		{"SIGHUP", cfg.Server.HangupGracePeriod},
+		{"SIGTERM", cfg.Server.TerminateGracePeriod},
+		{"SIGKILL", cfg.Server.PTYOperationTimeout},
 	}
 
-	if cfg.Server.UseTmux {
-		logger.PTYBridgeLogger.Info("Client disconnected from tmux session", logger.String("session", p.sessionName))
-	} else {
-		logger.PTYBridgeLogger.Info("Client disconnected from direct shell", logger.String("session", p.sessionName))
+	for _, step := range steps {
+		// /proc is scanned once per step, waiting only checks the groups
+		// found here
+		live := liveSessionGroups(sid)
+		if p.reaped() && len(live) == 0 {
+			break
+		}
+
+		groups := withLeaderGroup(sid, live)
+		logger.PTYBridgeLogger.Info("Terminating session processes",
+			logger.String("session", p.sessionName),
+			logger.String("signal", step.signal),
+			logger.Int("groups", len(groups)))
+
+		if err := signalProcessGroups(groups, step.signal); err != nil {
+			logger.PTYBridgeLogger.Warn("failed to signal session processes",
+				logger.String("session", p.sessionName),
+				logger.Error(err))
+			// Without process group signals the shell is all that can be stopped
+			p.cmd.Process.Kill()
+		} else if step.signal != "SIGKILL" {
+			// Stopped jobs only act on a signal once continued
+			signalProcessGroups(groups, "SIGCONT")
+		}
+
+		if p.waitSessionGone(sid, groups, step.grace) {
+			break
+		}
+	}
+
+	if !p.sessionGone(sid) {
+		logger.PTYBridgeLogger.Warn("Session processes survived SIGKILL", logger.String("session", p.sessionName))
 	}
 
 	if p.cancel != nil {
@@ -266,16 +726,58 @@ func (p *PTYBridge) Close() error {
 	}()
 
 	select {
-	case err := <-done:
-		return err
+	case p.closeErr = <-done:
 	case <-time.After(cfg.Server.PTYOperationTimeout):
 		logger.PTYBridgeLogger.Warn("PTY close operation timed out")
-		return fmt.Errorf("PTY close timeout after %v", cfg.Server.PTYOperationTimeout)
+		p.closeErr = fmt.Errorf("PTY close timeout after %v", cfg.Server.PTYOperationTimeout)
+	}
+}
+
+// reaped reports whether the child has exited and been reaped
+func (p *PTYBridge) reaped() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
 	}
 }
 
+// sessionGone reports whether the child has been reaped and nothing else is
+// running in its terminal session
+func (p *PTYBridge) sessionGone(sid int) bool {
+	return p.reaped() && len(liveSessionGroups(sid)) == 0
+}
+
+// waitSessionGone waits up to timeout for the terminal session to end.
+// Meanwhile it polls groups with a null signal, which is cheap, and scans
+// /proc to confirm once they are all gone or every teardownScanInterval.
+func (p *PTYBridge) waitSessionGone(sid int, groups []int, timeout time.Duration) bool {
+	ticker := time.NewTicker(teardownPollInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	scanned := time.Now()
+
+	for {
+		if p.reaped() && (!processGroupsAlive(groups) || time.Since(scanned) >= teardownScanInterval) {
+			live := liveSessionGroups(sid)
+			if len(live) == 0 {
+				return true
+			}
+			// Some processes may have moved to groups of their own
+			groups, scanned = live, time.Now()
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return p.sessionGone(sid)
+		}
+	}
+}
+
+// Done is closed once the child process has exited and been reaped
 func (p *PTYBridge) Done() <-chan struct{} {
-	return p.done
+	return p.exited
 }
 
 func (p *PTYBridge) Copy(dst io.Writer) {
@@ -287,14 +789,18 @@ func (p *PTYBridge) Copy(dst io.Writer) {
 // ============================================================================
 
 var (
-	_ interfaces.PTYReader         = (*PTYBridge)(nil)
-	_ interfaces.PTYWriter         = (*PTYBridge)(nil)
-	_ interfaces.PTYResizer        = (*PTYBridge)(nil)
-	_ interfaces.PTYInputProcessor = (*PTYBridge)(nil)
-	_ interfaces.PTYLifecycle      = (*PTYBridge)(nil)
-	_ interfaces.PTYManager        = (*PTYBridge)(nil)
-	_ interfaces.PTYCopier         = (*PTYBridge)(nil)
-	_ interfaces.PTYBridge         = (*PTYBridge)(nil)
+	_ interfaces.PTYReader           = (*PTYBridge)(nil)
+	_ interfaces.PTYWriter           = (*PTYBridge)(nil)
+	_ interfaces.PTYResizer          = (*PTYBridge)(nil)
+	_ interfaces.PTYInputProcessor   = (*PTYBridge)(nil)
+	_ interfaces.PTYLifecycle        = (*PTYBridge)(nil)
+	_ interfaces.PTYManager          = (*PTYBridge)(nil)
+	_ interfaces.PTYCopier           = (*PTYBridge)(nil)
+	_ interfaces.PTYBridge           = (*PTYBridge)(nil)
+	_ interfaces.PTYExitReporter     = (*PTYBridge)(nil)
+	_ interfaces.PTYProcessInspector = (*PTYBridge)(nil)
+	_ interfaces.PTYEventSource      = (*PTYBridge)(nil)
+	_ interfaces.PTYSignaler         = (*PTYBridge)(nil)
 )
 
 // ============================================================================
@@ -307,8 +813,8 @@ func NewFactory() interfaces.PTYBridgeFactory {
 	return &Factory{}
 }
 
-func (f *Factory) NewPTYBridge(ctx context.Context) (interfaces.PTYBridge, error) {
-	return New(ctx)
+func (f *Factory) NewPTYBridge(ctx context.Context, options interfaces.PTYOptions) (interfaces.PTYBridge, error) {
+	return NewWithOptions(ctx, options)
 }
 
 func NewPTYBridge(ctx context.Context) (interfaces.PTYBridge, error) {
@@ -0,0 +1,15 @@
+//go:build !darwin && !freebsd && !linux && !netbsd && !openbsd
+
+package ptybridge
+
+import "errors"
+
+func signalProcessGroups(groups []int, name string) error {
+	return errors.New("sending signals is not supported on this platform")
+}
+
+// processGroupsAlive can't check on this platform, so it leaves the decision
+// to the process scan
+func processGroupsAlive(groups []int) bool {
+	return false
+}
@@ -0,0 +1,46 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+
+package ptybridge
+
+import (
+	"fmt"
+	"syscall"
+)
+
+var signalsByName = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGSTOP": syscall.SIGSTOP,
+	"SIGCONT": syscall.SIGCONT,
+	"SIGQUIT": syscall.SIGQUIT,
+}
+
+// signalProcessGroups delivers the named signal to each process group,
+// ignoring groups that have already gone away
+func signalProcessGroups(groups []int, name string) error {
+	signal, ok := signalsByName[name]
+	if !ok {
+		return fmt.Errorf("unsupported signal %s", name)
+	}
+
+	var firstErr error
+	for _, pgid := range groups {
+		if err := syscall.Kill(-pgid, signal); err != nil && err != syscall.ESRCH && firstErr == nil {
+			firstErr = fmt.Errorf("failed to send %s to process group %d: %w", name, pgid, err)
+		}
+	}
+	return firstErr
+}
+
+// processGroupsAlive reports whether any of the process groups still has
+// members, zombies included
+func processGroupsAlive(groups []int) bool {
+	for _, pgid := range groups {
+		if err := syscall.Kill(-pgid, 0); err != syscall.ESRCH {
+			return true
+		}
+	}
+	return false
+}
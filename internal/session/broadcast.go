@@ -0,0 +1,375 @@
+package session
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var (
+	errBroadcastOptOut  = errors.New("session has opted out of broadcasts")
+	errBroadcastStalled = errors.New("session is not reading input")
+)
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+func encodeBroadcastStatus(targets, skipped []string, failures []protocol.BroadcastFailure) []byte {
+	if targets == nil {
+		targets = []string{}
+	}
+	data, err := json.Marshal(protocol.BroadcastStatusMessage{
+		Type:     protocol.TypeBroadcastStatus,
+		Targets:  targets,
+		Skipped:  skipped,
+		Failures: failures,
+	})
+	if err != nil {
+		logger.SessionLogger.Error("failed to encode broadcast status", err)
+		return nil
+	}
+	return data
+}
+
+// setBroadcastTargets replaces the sessions receiving this client's input
+// and returns the previous selection
+func (c *Client) setBroadcastTargets(ids []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := c.broadcastTargets
+	c.broadcastTargets = ids
+	c.broadcastStatus = nil
+	return previous
+}
+
+func (c *Client) broadcastTargetIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.broadcastTargets
+}
+
+// reportBroadcastStatus reports status to the client, see
+// reportBroadcastStatusLocked
+func (c *Client) reportBroadcastStatus(status []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reportBroadcastStatusLocked(status)
+}
+
+// reportBroadcastStatusLocked delivers a status message unless it repeats
+// the last one, so typing into a failing session does not flood the client.
+// Delivering under c.mu keeps reports in the order of the selections they
+// describe.
+func (c *Client) reportBroadcastStatusLocked(status []byte) {
+	if status == nil || bytes.Equal(c.broadcastStatus, status) {
+		return
+	}
+	c.broadcastStatus = status
+	c.deliver(Frame{Event: true, Data: status})
+}
+
+// settleBroadcast records the outcome of copying input to the sessions ids:
+// the ones still open in live stay selected and status is reported. Nothing
+// changes if the selection was replaced meanwhile, since the outcome then
+// describes one that no longer applies.
+func (c *Client) settleBroadcast(ids, live []string, status []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Equal(c.broadcastTargets, ids) {
+		return
+	}
+	c.broadcastTargets = live
+	c.reportBroadcastStatusLocked(status)
+}
+
+// ============================================================================
+// SESSION BROADCAST STATE
+// ============================================================================
+
+// ProcessClientInput handles a message from one client in the legacy
+// format, where control messages are told apart from keystrokes by parsing
+// them as JSON
+func (s *Session) ProcessClientInput(ctx context.Context, client *Client, data []byte) error {
+	if msgType, ok := protocol.DecodeType(data); ok && protocol.IsClientControl(msgType) {
+		return s.ClientControl(ctx, client, data)
+	}
+	return s.ClientInput(ctx, client, data)
+}
+
+// ClientInput forwards one client's keystrokes to the session and, while the
+// client is broadcasting, copies them to each selected session
+func (s *Session) ClientInput(ctx context.Context, client *Client, data []byte) error {
+	err := s.WriteInput(ctx, data)
+	if targets := client.broadcastTargetIDs(); len(targets) > 0 {
+		s.manager.broadcastInput(client, targets, data)
+	}
+	return err
+}
+
+// ClientControl handles a control message from one client. Broadcast
+// messages are handled here and everything else by ProcessControl.
+func (s *Session) ClientControl(ctx context.Context, client *Client, message []byte) error {
+	msgType, _ := protocol.DecodeType(message)
+	switch msgType {
+	case protocol.TypeBroadcast:
+		var broadcast protocol.BroadcastMessage
+		if err := json.Unmarshal(message, &broadcast); err != nil {
+			logger.SessionLogger.Error("failed to parse broadcast message", err)
+			return nil
+		}
+		s.manager.SetBroadcast(s, client, broadcast.Sessions)
+		return nil
+
+	case protocol.TypeBroadcastOptOut:
+		var optOut protocol.BroadcastOptOutMessage
+		if err := json.Unmarshal(message, &optOut); err != nil {
+			logger.SessionLogger.Error("failed to parse broadcast opt-out message", err)
+			return nil
+		}
+		s.SetBroadcastOptOut(optOut.OptOut)
+		return nil
+	}
+
+	return s.ProcessControl(ctx, message)
+}
+
+// SetBroadcastOptOut excludes the session from other clients' broadcasts
+func (s *Session) SetBroadcastOptOut(optOut bool) {
+	s.mu.Lock()
+	changed := s.broadcastOptOut != optOut
+	s.broadcastOptOut = optOut
+	s.mu.Unlock()
+
+	if changed {
+		logger.SessionLogger.Info("Broadcast opt-out changed",
+			logger.String("session", s.Name()),
+			logger.Bool("opt_out", optOut))
+		s.sendBroadcastState()
+	}
+}
+
+// acceptBroadcast queues input broadcast from another session. It never
+// waits, so a session that stopped reading input doesn't hold up the sender.
+func (s *Session) acceptBroadcast(data []byte) error {
+	s.mu.Lock()
+	optOut, exited := s.broadcastOptOut, s.exited
+	s.mu.Unlock()
+
+	if optOut {
+		return errBroadcastOptOut
+	}
+	if exited {
+		return ErrSessionExited
+	}
+	select {
+	case s.broadcastQueue <- data:
+		return nil
+	default:
+		return errBroadcastStalled
+	}
+}
+
+// pumpBroadcasts writes the input queued by acceptBroadcast until the
+// session closes
+func (s *Session) pumpBroadcasts() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.broadcastQueue:
+			s.mu.Lock()
+			bridge, exited := s.bridge, s.exited
+			s.mu.Unlock()
+			if exited {
+				continue
+			}
+			if _, err := bridge.Write(s.ctx, data); err != nil {
+				logger.SessionLogger.Warn("failed to write broadcast input",
+					logger.String("session", s.Name()),
+					logger.Error(err))
+			}
+		}
+	}
+}
+
+func (s *Session) addBroadcastSource(clientID, source string) {
+	s.mu.Lock()
+	if s.broadcastSources[clientID] == source {
+		s.mu.Unlock()
+		return
+	}
+	if s.broadcastSources == nil {
+		s.broadcastSources = make(map[string]string)
+	}
+	s.broadcastSources[clientID] = source
+	s.mu.Unlock()
+
+	s.sendBroadcastState()
+}
+
+func (s *Session) removeBroadcastSource(clientID string) {
+	s.mu.Lock()
+	_, ok := s.broadcastSources[clientID]
+	delete(s.broadcastSources, clientID)
+	s.mu.Unlock()
+
+	if ok {
+		s.sendBroadcastState()
+	}
+}
+
+// broadcastSourcesLocked lists the sessions broadcasting into this one
+func (s *Session) broadcastSourcesLocked() []string {
+	seen := make(map[string]bool)
+	sources := []string{}
+	for _, source := range s.broadcastSources {
+		if !seen[source] {
+			seen[source] = true
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func (s *Session) encodeBroadcastStateLocked() []byte {
+	data, err := json.Marshal(protocol.BroadcastStateMessage{
+		Type:    protocol.TypeBroadcastState,
+		Sources: s.broadcastSourcesLocked(),
+		OptOut:  s.broadcastOptOut,
+	})
+	if err != nil {
+		logger.SessionLogger.Error("failed to encode broadcast state", err)
+		return nil
+	}
+	return data
+}
+
+func (s *Session) sendBroadcastState() {
+	s.mu.Lock()
+	message := s.encodeBroadcastStateLocked()
+	s.mu.Unlock()
+
+	if message != nil {
+		s.broadcast(Frame{Event: true, Data: message})
+	}
+}
+
+// ============================================================================
+// MANAGER BROADCAST ROUTING
+// ============================================================================
+
+// SetBroadcast selects the sessions, by ID or name, that receive a copy of
+// the client's input. protocol.BroadcastAll selects every other session open
+// at this moment and an empty selection stops broadcasting.
+func (m *Manager) SetBroadcast(source *Session, client *Client, selectors []string) {
+	var targets []*Session
+	var failures []protocol.BroadcastFailure
+	selected := map[string]bool{source.id: true}
+	add := func(target *Session) {
+		if !selected[target.id] {
+			selected[target.id] = true
+			targets = append(targets, target)
+		}
+	}
+
+	for _, selector := range selectors {
+		if selector == protocol.BroadcastAll {
+			m.mu.RLock()
+			for _, target := range m.sessions {
+				add(target)
+			}
+			m.mu.RUnlock()
+			continue
+		}
+		target, ok := m.Get(selector)
+		if !ok {
+			failures = append(failures, protocol.BroadcastFailure{Session: selector, Error: ErrSessionNotFound.Error()})
+			continue
+		}
+		add(target)
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].createdAt.Before(targets[j].createdAt)
+	})
+
+	ids := make([]string, 0, len(targets))
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		ids = append(ids, target.id)
+		names = append(names, target.Name())
+	}
+
+	previous := client.setBroadcastTargets(ids)
+	for _, id := range previous {
+		if target, ok := m.Get(id); ok && !selected[id] {
+			target.removeBroadcastSource(client.id)
+		}
+	}
+	sourceName := source.Name()
+	for _, target := range targets {
+		target.addBroadcastSource(client.id, sourceName)
+	}
+
+	client.reportBroadcastStatus(encodeBroadcastStatus(names, nil, failures))
+	logger.SessionLogger.Info("Broadcast targets changed",
+		logger.String("session", sourceName),
+		logger.String("client", client.id),
+		logger.Int("targets", len(ids)))
+}
+
+// broadcastInput copies input to the client's target sessions and reports
+// sessions that opted out, aren't keeping up or could not be written to
+func (m *Manager) broadcastInput(client *Client, ids []string, data []byte) {
+	var live, names, skipped []string
+	var failures []protocol.BroadcastFailure
+
+	for _, id := range ids {
+		target, ok := m.Get(id)
+		if !ok {
+			continue
+		}
+		live = append(live, id)
+		name := target.Name()
+		names = append(names, name)
+
+		if err := target.acceptBroadcast(data); err != nil {
+			if errors.Is(err, errBroadcastOptOut) || errors.Is(err, errBroadcastStalled) {
+				skipped = append(skipped, name)
+				continue
+			}
+			logger.SessionLogger.Warn("failed to write broadcast input",
+				logger.String("session", name),
+				logger.Error(err))
+			failures = append(failures, protocol.BroadcastFailure{Session: name, Error: err.Error()})
+		}
+	}
+
+	// Closed sessions leave the group for good
+	client.settleBroadcast(ids, live, encodeBroadcastStatus(names, skipped, failures))
+}
+
+// clearBroadcast stops a departing client's broadcast
+func (m *Manager) clearBroadcast(client *Client) {
+	for _, id := range client.setBroadcastTargets(nil) {
+		if target, ok := m.Get(id); ok {
+			target.removeBroadcastSource(client.id)
+		}
+	}
+}
@@ -0,0 +1,1752 @@
+package session
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+	"github.com/PiTZE/PorTTY/internal/framing"
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/metrics"
+	"github.com/PiTZE/PorTTY/internal/multiplexer"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+	"github.com/PiTZE/PorTTY/internal/sessionstore"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+const (
+	ReasonDetached      = "detached"
+	ReasonSessionClosed = "session closed"
+	ReasonDisconnected  = "disconnected by administrator"
+	ReasonOverflow      = "output queue overflow"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrClientNotFound  = errors.New("client not found")
+	ErrNameInUse       = errors.New("session name already in use")
+	ErrInvalidName     = errors.New("invalid session name")
+	ErrSessionClosed   = errors.New("session closed")
+	ErrInvalidOptions  = errors.New("invalid session options")
+	ErrInvalidSignal   = errors.New("invalid signal")
+	ErrNotSignalable   = errors.New("session does not support signals")
+	ErrSessionExited   = errors.New("session process has exited")
+	ErrUnknownDir      = errors.New("session's current directory is unknown")
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// Frame is a unit of server output destined for attached clients. Sequence
+// is the position of the first byte of terminal output in everything the
+// session has written, so clients can resume where they left off.
+type Frame struct {
+	Event    bool
+	Data     []byte
+	Sequence uint64
+}
+
+// Client is a single connection attached to a session
+type Client struct {
+	id          string
+	remoteAddr  string
+	connectedAt time.Time
+	frames      chan Frame
+	done        chan struct{}
+	closeOnce   sync.Once
+	reason      string
+
+	mu               sync.Mutex
+	broadcastTargets []string
+	broadcastStatus  []byte
+
+	// pending counts output bytes queued or sent but not yet acknowledged;
+	// the session only waits on clients with flow control enabled
+	pending     atomic.Int64
+	flowControl atomic.Bool
+	credit      chan struct{}
+
+	// The latest round trip time measured on the client's connection
+	rtt    atomic.Int64
+	jitter atomic.Int64
+}
+
+// Options configures how a session behaves
+type Options struct {
+	Profile      string
+	Args         []string
+	ExitAction   string
+	RespawnDelay time.Duration
+	Launch       interfaces.PTYOptions
+	History      []byte
+}
+
+// history keeps the most recent output of a session
+type history struct {
+	mu    sync.Mutex
+	data  []byte
+	limit int
+	end   uint64
+}
+
+// Session owns a PTY bridge and fans its output out to attached clients
+type Session struct {
+	id         string
+	name       string
+	createdAt  time.Time
+	options    Options
+	bridge     interfaces.PTYBridge
+	manager    *Manager
+	mu         sync.Mutex
+	clients    map[string]*Client
+	scrollback *history
+	process    []byte
+	exited     bool
+	respawning bool
+	lastResize []byte
+
+	resumeToken string
+	linger      *time.Timer
+
+	// credit is signalled when clients acknowledge output or leave, waking
+	// an output pump paused for a client that fell behind
+	credit chan struct{}
+
+	broadcastSources map[string]string
+	broadcastOptOut  bool
+	// broadcastQueue holds input broadcast from other sessions until
+	// pumpBroadcasts writes it
+	broadcastQueue chan []byte
+
+	// persistMu is held while the session's record is written or removed,
+	// so a save that started before the session closed can't write the
+	// record back after it was removed
+	persistMu sync.Mutex
+	removed   bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// Manager tracks all live sessions on the server
+type Manager struct {
+	ptyFactory   interfaces.PTYBridgeFactory
+	mu           sync.RWMutex
+	sessions     map[string]*Session
+	sequence     int
+	store        *sessionstore.Store
+	shuttingDown bool
+	closing      sync.WaitGroup
+}
+
+// ClientInfo describes an attached client
+type ClientInfo struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	// Round trip time and its variation in milliseconds, once measured
+	RTT    float64 `json:"rtt_ms,omitempty"`
+	Jitter float64 `json:"jitter_ms,omitempty"`
+}
+
+// ProcessInfo describes the foreground process of a session
+type ProcessInfo struct {
+	PID     int      `json:"pid"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Cwd     string   `json:"cwd,omitempty"`
+}
+
+// BroadcastInfo describes the broadcasts a session is receiving
+type BroadcastInfo struct {
+	Sources []string `json:"sources,omitempty"`
+	OptOut  bool     `json:"opt_out,omitempty"`
+}
+
+// Info describes a session and its attached clients
+type Info struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Profile   string         `json:"profile,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	Exited    bool           `json:"exited"`
+	Broadcast *BroadcastInfo `json:"broadcast,omitempty"`
+	Process   *ProcessInfo   `json:"process,omitempty"`
+	Clients   []ClientInfo   `json:"clients"`
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// generateToken returns a secret identifying a session to resuming clients
+func generateToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// signal wakes a waiter on a channel with a buffer of one without blocking
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func generateID() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func validateName(name string) error {
+	if name == "" || len(name) > 64 {
+		return ErrInvalidName
+	}
+	for _, r := range name {
+		if r <= ' ' || r == '/' || r == 0x7f {
+			return ErrInvalidName
+		}
+	}
+	return nil
+}
+
+// DefaultOptions returns session options taken from the configuration
+func DefaultOptions() Options {
+	return Options{
+		Profile:      cfg.Terminal.DefaultProfile,
+		ExitAction:   cfg.Terminal.ExitAction,
+		RespawnDelay: cfg.Terminal.RespawnDelay,
+	}
+}
+
+func (o Options) validate() error {
+	switch o.ExitAction {
+	case protocol.ExitActionClose, protocol.ExitActionKeep, protocol.ExitActionRespawn:
+	default:
+		return fmt.Errorf("%w: unknown exit action %q (use %s, %s or %s)", ErrInvalidOptions, o.ExitAction,
+			protocol.ExitActionClose, protocol.ExitActionKeep, protocol.ExitActionRespawn)
+	}
+	if o.RespawnDelay < 0 {
+		return fmt.Errorf("%w: respawn delay cannot be negative", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// withProfile fills in the launch options from the selected profile. The
+// profile's directory only applies when no directory was requested, and
+// explicitly requested variables override the profile's.
+func (o Options) withProfile() (Options, error) {
+	if o.Profile == "" {
+		if len(o.Args) > 0 {
+			return o, fmt.Errorf("%w: arguments require a profile", ErrInvalidOptions)
+		}
+		return o, nil
+	}
+
+	profile, ok := cfg.Profiles[o.Profile]
+	if !ok {
+		return o, fmt.Errorf("%w: unknown profile %q", ErrInvalidOptions, o.Profile)
+	}
+	if profile.Command == "" {
+		return o, fmt.Errorf("%w: profile %q has no command", ErrInvalidOptions, o.Profile)
+	}
+	if err := checkArgs(o.Profile, profile.Params, o.Args); err != nil {
+		return o, err
+	}
+
+	o.Launch.Profile = o.Profile
+	o.Launch.Command = profile.Command
+	o.Launch.Args = append(append([]string(nil), profile.Args...), o.Args...)
+	o.Launch.Term = profile.Term
+	o.Launch.ColorTerm = profile.ColorTerm
+	o.Launch.Multiplexer = profile.Multiplexer
+	if o.Launch.Dir == "" {
+		o.Launch.Dir = profile.Cwd
+	}
+	o.Launch.Env = append(profile.Environment(), o.Launch.Env...)
+	return o, nil
+}
+
+// withDirectory picks the starting directory, falling back to the configured
+// working directory, and checks that it exists
+func (o Options) withDirectory() (Options, error) {
+	dir := o.Launch.Dir
+	if dir == "" {
+		dir = cfg.Terminal.WorkingDirectory
+	}
+	if dir == "" {
+		return o, nil
+	}
+
+	expanded, err := expandHome(dir)
+	if err != nil {
+		return o, fmt.Errorf("%w: working directory %q: %v", ErrInvalidOptions, dir, err)
+	}
+	if info, err := os.Stat(expanded); err != nil || !info.IsDir() {
+		return o, fmt.Errorf("%w: working directory %q does not exist", ErrInvalidOptions, dir)
+	}
+
+	o.Launch.Dir = expanded
+	return o, nil
+}
+
+// expandHome replaces a leading ~ or ~user with the home directory
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	name, rest, _ := strings.Cut(path[1:], "/")
+	var home string
+	if name == "" {
+		dir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		home = dir
+	} else {
+		account, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		home = account.HomeDir
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// checkArgs validates client supplied arguments against the positional
+// parameters a profile declares. Values are passed to the program as separate
+// argv entries, so matching the pattern is the only check they need.
+func checkArgs(profile string, params []config.ProfileParam, args []string) error {
+	if len(args) > len(params) {
+		if len(params) == 0 {
+			return fmt.Errorf("%w: profile %q does not accept arguments", ErrInvalidOptions, profile)
+		}
+		return fmt.Errorf("%w: profile %q accepts at most %d arguments, got %d", ErrInvalidOptions, profile, len(params), len(args))
+	}
+
+	for i, param := range params {
+		label := param.Name
+		if label == "" {
+			label = fmt.Sprintf("argument %d", i+1)
+		}
+		if i >= len(args) {
+			if param.Required {
+				return fmt.Errorf("%w: profile %q requires %s", ErrInvalidOptions, profile, label)
+			}
+			continue
+		}
+
+		if !param.Matches(args[i]) {
+			return fmt.Errorf("%w: %s %q is not allowed by profile %q", ErrInvalidOptions, label, args[i], profile)
+		}
+	}
+	return nil
+}
+
+// restorableEnv reports whether a variable is carried over when a session is
+// restored. Only the variables named in restore_env are, since the
+// environment of a shell often holds tokens that must not end up on disk; a
+// name ending in * matches every variable starting with the rest.
+func restorableEnv(key string) bool {
+	for _, name := range cfg.Server.RestoreEnv {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func filterEnv(env []string) []string {
+	filtered := make([]string, 0, len(env))
+	for _, entry := range env {
+		key, _, ok := strings.Cut(entry, "=")
+		if !ok || !restorableEnv(key) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
+// restoredHistory frames saved scrollback so it renders as inert history
+// above the prompt of the restored shell
+func restoredHistory(scrollback []byte, savedAt time.Time) []byte {
+	// Start at a line boundary so a partially saved escape sequence is not replayed
+	if i := bytes.IndexByte(scrollback, '\n'); i >= 0 {
+		scrollback = scrollback[i+1:]
+	}
+
+	var buf bytes.Buffer
+	buf.Write(scrollback)
+	buf.WriteString("\x1b[0m\x1b[?1049l\x1b[?25h\r\n")
+	fmt.Fprintf(&buf, "\x1b[2m--- session restored; history above was saved %s ---\x1b[0m\r\n", savedAt.Local().Format(time.DateTime))
+	return buf.Bytes()
+}
+
+func encodeSessionMessage(id, name string) []byte {
+	data, err := protocol.Encode(protocol.SessionMessage{Type: protocol.TypeSession, ID: id, Name: name})
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// ============================================================================
+// HISTORY
+// ============================================================================
+
+func newHistory(limit int, initial []byte) *history {
+	h := &history{limit: limit}
+	h.Write(initial)
+	return h
+}
+
+// Write appends output, discarding the oldest bytes beyond the limit, and
+// returns the sequence of its first byte
+func (h *history) Write(p []byte) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sequence := h.end
+	h.end += uint64(len(p))
+	if h.limit <= 0 || len(p) == 0 {
+		return sequence
+	}
+
+	h.data = append(h.data, p...)
+	if len(h.data) > 2*h.limit {
+		h.data = append([]byte(nil), h.data[len(h.data)-h.limit:]...)
+	}
+	return sequence
+}
+
+// Since returns a copy of the output written after sequence, or false when
+// part of it has already been discarded
+func (h *history) Since(sequence uint64) ([]byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data := h.data
+	if len(data) > h.limit {
+		data = data[len(data)-h.limit:]
+	}
+	start := h.end - uint64(len(data))
+	if sequence < start || sequence > h.end {
+		return nil, false
+	}
+	return append([]byte(nil), data[sequence-start:]...), true
+}
+
+// End returns the sequence following the last byte written
+func (h *history) End() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.end
+}
+
+// Bytes returns a copy of the retained output
+func (h *history) Bytes() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data := h.data
+	if len(data) > h.limit {
+		data = data[len(data)-h.limit:]
+	}
+	return append([]byte(nil), data...)
+}
+
+// ============================================================================
+// CLIENT
+// ============================================================================
+
+func newClient(remoteAddr string) *Client {
+	return &Client{
+		id:          generateID(),
+		remoteAddr:  remoteAddr,
+		connectedAt: time.Now(),
+		frames:      make(chan Frame, cfg.WebSocket.MessageChannelBuffer),
+		done:        make(chan struct{}),
+	}
+}
+
+// ID returns the unique client identifier
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Frames returns the queue of output destined for this client
+func (c *Client) Frames() <-chan Frame {
+	return c.frames
+}
+
+// Done is closed when the client has been detached from its session
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Reason returns why the client was detached
+func (c *Client) Reason() string {
+	return c.reason
+}
+
+func (c *Client) close(reason string) {
+	c.closeOnce.Do(func() {
+		c.reason = reason
+		close(c.done)
+	})
+}
+
+// Acknowledge returns credit for n bytes of output the client has processed
+func (c *Client) Acknowledge(n int) {
+	if c.pending.Add(-int64(n)) < 0 {
+		c.pending.Store(0)
+	}
+	signal(c.credit)
+}
+
+// EnableFlowControl makes the session pause reading output while this client
+// is too far behind. The client must acknowledge the output it processes.
+func (c *Client) EnableFlowControl() {
+	c.flowControl.Store(true)
+}
+
+// SetLatency records the round trip time measured on the client's connection
+func (c *Client) SetLatency(rtt, jitter time.Duration) {
+	c.rtt.Store(int64(rtt))
+	c.jitter.Store(int64(jitter))
+}
+
+func (c *Client) deliver(frame Frame) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+	}
+
+	select {
+	case c.frames <- frame:
+		if !frame.Event {
+			c.pending.Add(int64(len(frame.Data)))
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// behind reports whether a flow controlled client has more than mark bytes
+// of output outstanding
+func (c *Client) behind(mark int64) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	return c.flowControl.Load() && c.pending.Load() > mark
+}
+
+func (c *Client) info() ClientInfo {
+	return ClientInfo{
+		ID:          c.id,
+		RemoteAddr:  c.remoteAddr,
+		ConnectedAt: c.connectedAt,
+		RTT:         metrics.Milliseconds(time.Duration(c.rtt.Load())),
+		Jitter:      metrics.Milliseconds(time.Duration(c.jitter.Load())),
+	}
+}
+
+// ============================================================================
+// SESSION
+// ============================================================================
+
+// ID returns the unique session identifier
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Name returns the current session name
+func (s *Session) Name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+// ResumeToken returns the secret clients present to resume the session
+func (s *Session) ResumeToken() string {
+	return s.resumeToken
+}
+
+// Terminal returns the settings the session's terminal was started with,
+// with unset launch options replaced by their configured defaults
+func (s *Session) Terminal() protocol.TerminalSettings {
+	launch := s.options.Launch
+	settings := protocol.TerminalSettings{
+		Term:       launch.Term,
+		ColorTerm:  launch.ColorTerm,
+		Cols:       launch.Cols,
+		Rows:       launch.Rows,
+		Profile:    s.options.Profile,
+		ExitAction: s.options.ExitAction,
+	}
+	// Profiles choose their own multiplexer, like the PTY bridge does
+	multiplexerName := cfg.Server.Multiplexer
+	if launch.Command != "" {
+		multiplexerName = launch.Multiplexer
+	}
+	if multiplexer.Enabled(multiplexerName) {
+		settings.Multiplexer = multiplexerName
+	}
+	if settings.Term == "" {
+		settings.Term = cfg.Terminal.DefaultTerm
+	}
+	if settings.ColorTerm == "" {
+		settings.ColorTerm = cfg.Terminal.DefaultColor
+	}
+	if settings.Cols <= 0 || settings.Rows <= 0 {
+		settings.Cols, settings.Rows = cfg.Terminal.DefaultCols, cfg.Terminal.DefaultRows
+	}
+	return settings
+}
+
+// Bridge returns the PTY bridge currently backing the session
+func (s *Session) Bridge() interfaces.PTYBridge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bridge
+}
+
+// Done is closed once the session has terminated
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// AddClient attaches a new client to the session
+func (s *Session) AddClient(remoteAddr string) (*Client, error) {
+	client, _, err := s.addClient(remoteAddr, nil)
+	return client, err
+}
+
+// ResumeClient attaches a client that has seen the session's output up to
+// sequence. Only the output it missed is replayed, unless that is no longer
+// retained, in which case it gets the whole scrollback and false.
+func (s *Session) ResumeClient(remoteAddr string, sequence uint64) (*Client, bool, error) {
+	return s.addClient(remoteAddr, &sequence)
+}
+
+func (s *Session) addClient(remoteAddr string, resume *uint64) (*Client, bool, error) {
+	// The state is read before taking s.mu, since the bridge may need its
+	// events pumped to answer
+	var state [][]byte
+	if reporter, ok := s.Bridge().(interfaces.PTYStateReporter); ok {
+		state = reporter.State()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil, false, ErrSessionClosed
+	default:
+	}
+
+	if s.linger != nil {
+		s.linger.Stop()
+		s.linger = nil
+	}
+
+	client := newClient(remoteAddr)
+	client.credit = s.credit
+	s.clients[client.id] = client
+
+	// Output is written to the scrollback and delivered under s.mu, so the
+	// replay neither misses nor repeats any of it
+	resumed := false
+	if resume != nil {
+		if missed, ok := s.scrollback.Since(*resume); ok {
+			resumed = true
+			if len(missed) > 0 {
+				client.deliver(Frame{Data: missed, Sequence: *resume})
+			}
+		}
+	}
+	if !resumed {
+		if scrollback := s.scrollback.Bytes(); len(scrollback) > 0 {
+			client.deliver(Frame{Data: scrollback, Sequence: s.scrollback.End() - uint64(len(scrollback))})
+		}
+	}
+	if message := encodeSessionMessage(s.id, s.name); message != nil {
+		client.deliver(Frame{Event: true, Data: message})
+	}
+	if s.process != nil {
+		client.deliver(Frame{Event: true, Data: s.process})
+	}
+	for _, event := range state {
+		client.deliver(Frame{Event: true, Data: event})
+	}
+	if len(s.broadcastSources) > 0 || s.broadcastOptOut {
+		if message := s.encodeBroadcastStateLocked(); message != nil {
+			client.deliver(Frame{Event: true, Data: message})
+		}
+	}
+
+	logger.SessionLogger.Info("Client attached to session",
+		logger.String("session", s.name),
+		logger.String("client", client.id),
+		logger.String("remote", remoteAddr),
+		logger.Bool("resumed", resumed))
+
+	return client, resumed, nil
+}
+
+// RemoveClient detaches a client and closes the session once it is empty
+func (s *Session) RemoveClient(clientID string) {
+	s.removeClient(clientID, false)
+}
+
+// SuspendClient detaches a client that lost its connection and may resume.
+// When it was the last client, the session is kept open for the resume
+// timeout instead of closing right away.
+func (s *Session) SuspendClient(clientID string) {
+	s.removeClient(clientID, cfg.WebSocket.ResumeTimeout > 0)
+}
+
+func (s *Session) removeClient(clientID string, linger bool) {
+	s.mu.Lock()
+	client, ok := s.clients[clientID]
+	if ok {
+		delete(s.clients, clientID)
+	}
+	remaining := len(s.clients)
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	s.manager.clearBroadcast(client)
+	client.close(ReasonDetached)
+	s.signalCredit()
+	logger.SessionLogger.Info("Client detached from session",
+		logger.String("session", s.Name()),
+		logger.String("client", clientID))
+
+	if remaining > 0 {
+		return
+	}
+	if !linger {
+		s.Close()
+		return
+	}
+
+	s.mu.Lock()
+	if len(s.clients) == 0 && s.linger == nil {
+		s.linger = time.AfterFunc(cfg.WebSocket.ResumeTimeout, s.expireLinger)
+	}
+	s.mu.Unlock()
+	logger.SessionLogger.Info("Keeping session open for the client to resume",
+		logger.String("session", s.Name()),
+		logger.Duration("timeout", cfg.WebSocket.ResumeTimeout))
+}
+
+// expireLinger closes a session nobody resumed in time
+func (s *Session) expireLinger() {
+	s.mu.Lock()
+	if len(s.clients) > 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.linger = nil
+	s.mu.Unlock()
+
+	logger.SessionLogger.Info("No client resumed the session, closing it", logger.String("session", s.Name()))
+	s.Close()
+}
+
+func (s *Session) signalCredit() {
+	signal(s.credit)
+}
+
+// awaitCredit holds off reading more output while a flow controlled client
+// has more than the high-water mark outstanding, until every such client is
+// back under the low-water mark. Clients that stop acknowledging output are
+// disconnected after the ack timeout so they cannot stall the session.
+func (s *Session) awaitCredit(bridge interfaces.PTYBridge) bool {
+	high := int64(cfg.WebSocket.FlowHighWater)
+	if high <= 0 || !s.clientsBehind(high) {
+		return true
+	}
+	low := int64(cfg.WebSocket.FlowLowWater)
+	if low > high {
+		low = high
+	}
+
+	stall := time.NewTimer(cfg.WebSocket.AckTimeout)
+	defer stall.Stop()
+
+	for s.clientsBehind(low) {
+		select {
+		case <-s.credit:
+			if !stall.Stop() {
+				<-stall.C
+			}
+			stall.Reset(cfg.WebSocket.AckTimeout)
+		case <-stall.C:
+			s.dropStalled(low)
+			stall.Reset(cfg.WebSocket.AckTimeout)
+		case <-bridge.Done():
+			return true
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Session) clientsBehind(mark int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, client := range s.clients {
+		if client.behind(mark) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropStalled disconnects flow controlled clients that are still behind;
+// they may reconnect and resume
+func (s *Session) dropStalled(mark int64) {
+	s.mu.Lock()
+	var stalled []*Client
+	for _, client := range s.clients {
+		if client.behind(mark) {
+			stalled = append(stalled, client)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, client := range stalled {
+		logger.SessionLogger.Warn("client stopped acknowledging output, disconnecting",
+			logger.String("session", s.Name()),
+			logger.String("client", client.id))
+		client.close(ReasonOverflow)
+	}
+}
+
+// DisconnectClient forcibly detaches a client from the session
+func (s *Session) DisconnectClient(clientID, reason string) bool {
+	s.mu.Lock()
+	client, ok := s.clients[clientID]
+	s.mu.Unlock()
+
+	if ok {
+		client.close(reason)
+	}
+	return ok
+}
+
+// ProcessInput handles a message in the legacy format, where control
+// messages are told apart from keystrokes by parsing them as JSON
+func (s *Session) ProcessInput(ctx context.Context, data []byte) error {
+	if msgType, ok := protocol.DecodeType(data); ok && protocol.IsClientControl(msgType) {
+		return s.ProcessControl(ctx, data)
+	}
+	return s.WriteInput(ctx, data)
+}
+
+// WriteInput forwards keystrokes to the PTY bridge. While an exited session
+// is kept open, input is discarded and Enter restarts the shell.
+func (s *Session) WriteInput(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	bridge, exited := s.bridge, s.exited
+	s.mu.Unlock()
+
+	if exited {
+		if s.options.ExitAction == protocol.ExitActionKeep && bytes.ContainsAny(data, "\r\n") {
+			go s.respawn(0)
+		}
+		return nil
+	}
+
+	_, err := bridge.Write(ctx, data)
+	return err
+}
+
+// ProcessControl handles a JSON control message from a client. Unknown
+// message types are ignored.
+func (s *Session) ProcessControl(ctx context.Context, message []byte) error {
+	msgType, ok := protocol.DecodeType(message)
+	if !ok {
+		logger.SessionLogger.Warn("ignoring malformed control message", logger.String("session", s.Name()))
+		return nil
+	}
+
+	switch msgType {
+	case protocol.TypeSignal:
+		var signal protocol.SignalMessage
+		if err := json.Unmarshal(message, &signal); err != nil {
+			logger.SessionLogger.Error("failed to parse signal message", err)
+			return nil
+		}
+		if err := s.Signal(signal.Signal, signal.Target); err != nil {
+			logger.SessionLogger.Warn("failed to deliver signal", logger.String("session", s.Name()), logger.Error(err))
+		}
+		return nil
+
+	case protocol.TypeKeepalive:
+		return nil
+
+	case protocol.TypeResize, protocol.TypeTmuxInput, protocol.TypeTmuxCommand:
+		s.mu.Lock()
+		if msgType == protocol.TypeResize {
+			s.lastResize = append([]byte(nil), message...)
+		}
+		bridge, exited := s.bridge, s.exited
+		s.mu.Unlock()
+
+		if exited {
+			return nil
+		}
+		return bridge.ProcessInput(ctx, message)
+	}
+
+	logger.SessionLogger.Warn("ignoring unknown control message",
+		logger.String("session", s.Name()),
+		logger.String("type", msgType))
+	return nil
+}
+
+// Signal delivers a signal to the session's foreground process group or,
+// with protocol.SignalTargetSession, to every process in the session
+func (s *Session) Signal(name, target string) error {
+	signal, ok := protocol.NormalizeSignal(name)
+	if !ok {
+		return fmt.Errorf("%w %q (supported: %s)", ErrInvalidSignal, name, strings.Join(protocol.Signals, ", "))
+	}
+	if target == "" {
+		target = protocol.SignalTargetForeground
+	}
+	if target != protocol.SignalTargetForeground && target != protocol.SignalTargetSession {
+		return fmt.Errorf("%w: unknown target %q (use %s or %s)", ErrInvalidSignal, target,
+			protocol.SignalTargetForeground, protocol.SignalTargetSession)
+	}
+
+	s.mu.Lock()
+	bridge, exited := s.bridge, s.exited
+	s.mu.Unlock()
+
+	if exited {
+		return ErrSessionExited
+	}
+	// Behind a multiplexer the terminal's processes are its attach client,
+	// and signalling that would detach or kill it instead of the job
+	if multiplexer := s.Terminal().Multiplexer; multiplexer != "" {
+		return fmt.Errorf("%w: it runs inside %s", ErrNotSignalable, multiplexer)
+	}
+	signaler, ok := bridge.(interfaces.PTYSignaler)
+	if !ok {
+		return ErrNotSignalable
+	}
+
+	logger.SessionLogger.Info("Signalling session",
+		logger.String("session", s.Name()),
+		logger.String("signal", signal),
+		logger.String("target", target))
+	return signaler.Signal(signal, target)
+}
+
+// Close terminates the session, its bridge and all attached clients
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.manager.closing.Add(1)
+		defer s.manager.closing.Done()
+
+		close(s.done)
+		s.cancel()
+
+		// Tearing the shell down can take a while; free the name for new
+		// sessions in the meantime
+		s.manager.remove(s.id)
+
+		s.mu.Lock()
+		bridge := s.bridge
+		s.mu.Unlock()
+		err = bridge.Close()
+
+		s.mu.Lock()
+		clients := make([]*Client, 0, len(s.clients))
+		for _, client := range s.clients {
+			clients = append(clients, client)
+		}
+		s.clients = make(map[string]*Client)
+		s.mu.Unlock()
+
+		for _, client := range clients {
+			s.manager.clearBroadcast(client)
+			client.close(ReasonSessionClosed)
+		}
+
+		logger.SessionLogger.Info("Session closed", logger.String("session", s.Name()), logger.String("id", s.id))
+	})
+	return err
+}
+
+// Info returns a snapshot of the session state
+func (s *Session) Info() Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client.info())
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+
+	info := Info{
+		ID:        s.id,
+		Name:      s.name,
+		Profile:   s.options.Profile,
+		CreatedAt: s.createdAt,
+		Exited:    s.exited,
+		Clients:   clients,
+	}
+	if len(s.broadcastSources) > 0 || s.broadcastOptOut {
+		info.Broadcast = &BroadcastInfo{Sources: s.broadcastSourcesLocked(), OptOut: s.broadcastOptOut}
+	}
+
+	if inspector, ok := s.bridge.(interfaces.PTYProcessInspector); ok && !s.exited {
+		if pid, args, cwd := inspector.ForegroundProcess(); pid > 0 {
+			command := ""
+			if len(args) > 0 {
+				command = strings.TrimPrefix(filepath.Base(args[0]), "-")
+			}
+			info.Process = &ProcessInfo{PID: pid, Command: command, Args: args, Cwd: cwd}
+		}
+	}
+
+	return info
+}
+
+// WorkingDirectory returns the current directory of the session's foreground
+// process, falling back to the directory the session was started in. Inside
+// a multiplexer that only tells where the session began, not where the pane
+// being looked at is, so there is no fallback.
+func (s *Session) WorkingDirectory() (string, error) {
+	s.mu.Lock()
+	bridge, exited := s.bridge, s.exited
+	s.mu.Unlock()
+
+	if exited {
+		return "", ErrSessionExited
+	}
+	if reporter, ok := bridge.(interfaces.PTYDirectoryReporter); ok {
+		if cwd := reporter.WorkingDirectory(); cwd != "" {
+			return cwd, nil
+		}
+	}
+	if s.Terminal().Multiplexer != "" {
+		return "", ErrUnknownDir
+	}
+	if s.options.Launch.Dir != "" {
+		return s.options.Launch.Dir, nil
+	}
+	// Without a directory of its own the shell started in the server's
+	return os.Getwd()
+}
+
+// record captures the state needed to recreate the session after a restart
+func (s *Session) record() sessionstore.Record {
+	s.mu.Lock()
+	name, bridge := s.name, s.bridge
+	s.mu.Unlock()
+
+	record := sessionstore.Record{
+		Name:       name,
+		Profile:    s.options.Profile,
+		Args:       s.options.Args,
+		ExitAction: s.options.ExitAction,
+		Cwd:        s.options.Launch.Dir,
+		Env:        filterEnv(s.options.Launch.Env),
+		Scrollback: s.scrollback.Bytes(),
+		CreatedAt:  s.createdAt,
+		SavedAt:    time.Now(),
+	}
+
+	if inspector, ok := bridge.(interfaces.PTYProcessInspector); ok {
+		if cwd := inspector.WorkingDirectory(); cwd != "" {
+			record.Cwd = cwd
+		}
+		if env := inspector.Environment(); env != nil {
+			record.Env = filterEnv(env)
+		}
+	}
+
+	return record
+}
+
+func (s *Session) broadcast(frame Frame) {
+	s.mu.Lock()
+	slow := s.deliverLocked(frame)
+	s.mu.Unlock()
+
+	s.dropSlow(slow)
+}
+
+// broadcastOutput records terminal output in the scrollback and delivers it,
+// stamped with its sequence
+func (s *Session) broadcastOutput(data []byte) {
+	s.mu.Lock()
+	slow := s.deliverLocked(Frame{Data: data, Sequence: s.scrollback.Write(data)})
+	s.mu.Unlock()
+
+	s.dropSlow(slow)
+}
+
+// deliverLocked queues a frame for every client and returns the clients
+// whose queues were full
+func (s *Session) deliverLocked(frame Frame) []*Client {
+	var slow []*Client
+	for _, client := range s.clients {
+		if !client.deliver(frame) {
+			slow = append(slow, client)
+		}
+	}
+	return slow
+}
+
+func (s *Session) dropSlow(slow []*Client) {
+	for _, client := range slow {
+		logger.SessionLogger.Warn("client output queue full, disconnecting",
+			logger.String("session", s.Name()),
+			logger.String("client", client.id))
+		client.close(ReasonOverflow)
+	}
+}
+
+func (s *Session) start(bridge interfaces.PTYBridge) {
+	go s.pumpOutput(bridge)
+	if source, ok := bridge.(interfaces.PTYEventSource); ok {
+		go s.pumpEvents(bridge, source)
+	}
+}
+
+func (s *Session) pumpOutput(bridge interfaces.PTYBridge) {
+	defer s.handleExit(bridge)
+
+	// Output is broadcast in chunks that end on a character and escape
+	// sequence boundary, whatever the size of the reads
+	framer := framing.New(framing.DefaultOptions(), s.broadcastOutput)
+	defer framer.Flush()
+
+	buf := make([]byte, cfg.WebSocket.MaxMessageSize)
+	for {
+		if !s.awaitCredit(bridge) {
+			return
+		}
+
+		n, err := bridge.Read(s.ctx, buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			framer.Write(data)
+		}
+
+		if err != nil {
+			if err == io.EOF || err == io.ErrClosedPipe || err == io.ErrUnexpectedEOF ||
+				err == context.Canceled || err == context.DeadlineExceeded {
+				return
+			}
+
+			select {
+			case <-time.After(cfg.WebSocket.ErrorRetryDelay):
+			case <-bridge.Done():
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (s *Session) pumpEvents(bridge interfaces.PTYBridge, source interfaces.PTYEventSource) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-bridge.Done():
+			return
+		case event := <-source.Events():
+			if msgType, ok := protocol.DecodeType(event); ok && msgType == protocol.TypeProcess {
+				s.mu.Lock()
+				s.process = event
+				s.mu.Unlock()
+			}
+			s.broadcast(Frame{Event: true, Data: event})
+		}
+	}
+}
+
+// handleExit reports the end of a bridge to clients and applies the
+// session's exit action
+func (s *Session) handleExit(bridge interfaces.PTYBridge) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	if s.ctx.Err() != nil {
+		s.Close()
+		return
+	}
+
+	s.mu.Lock()
+	if s.bridge != bridge || s.exited {
+		s.mu.Unlock()
+		return
+	}
+	s.exited = true
+	s.mu.Unlock()
+
+	message := protocol.ExitedMessage{
+		Type:   protocol.TypeExited,
+		Code:   -1,
+		Action: s.options.ExitAction,
+	}
+	if reporter, ok := bridge.(interfaces.PTYExitReporter); ok {
+		select {
+		case <-reporter.Exited():
+			code, signal := reporter.ExitStatus()
+			message.Code = code
+			if sig, ok := signal.(syscall.Signal); ok {
+				message.Signal = int(sig)
+				message.SignalName = sig.String()
+			}
+		case <-time.After(cfg.Server.PTYOperationTimeout):
+		}
+	}
+	if s.options.ExitAction == protocol.ExitActionRespawn {
+		message.RespawnDelay = s.options.RespawnDelay.Milliseconds()
+	}
+
+	logger.SessionLogger.Info("Session process exited",
+		logger.String("session", s.Name()),
+		logger.Int("code", message.Code),
+		logger.String("action", s.options.ExitAction))
+
+	if data, err := protocol.Encode(message); err == nil {
+		s.broadcast(Frame{Event: true, Data: data})
+	}
+
+	switch s.options.ExitAction {
+	case protocol.ExitActionKeep:
+		bridge.Close()
+	case protocol.ExitActionRespawn:
+		bridge.Close()
+		go s.respawn(s.options.RespawnDelay)
+	default:
+		s.Close()
+	}
+}
+
+// respawn replaces an exited bridge with a fresh one after delay
+func (s *Session) respawn(delay time.Duration) {
+	s.mu.Lock()
+	if !s.exited || s.respawning {
+		s.mu.Unlock()
+		return
+	}
+	s.respawning = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.respawning = false
+		s.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(delay):
+	case <-s.done:
+		return
+	}
+
+	// Start the new shell at the size the clients last reported
+	launch := s.options.Launch
+	s.mu.Lock()
+	var resize protocol.ResizeMessage
+	if s.lastResize != nil && json.Unmarshal(s.lastResize, &resize) == nil {
+		launch.Rows, launch.Cols = resize.Dimensions.Rows, resize.Dimensions.Cols
+		launch.Width, launch.Height = resize.Dimensions.Width, resize.Dimensions.Height
+	}
+	s.mu.Unlock()
+
+	bridge, err := s.manager.ptyFactory.NewPTYBridge(s.ctx, launch)
+	if err != nil {
+		logger.SessionLogger.Error("failed to respawn session", err, logger.String("session", s.Name()))
+		s.Close()
+		return
+	}
+
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		bridge.Close()
+		return
+	default:
+	}
+	s.bridge = bridge
+	s.exited = false
+	s.mu.Unlock()
+
+	s.start(bridge)
+
+	logger.SessionLogger.Info("Session respawned", logger.String("session", s.Name()))
+}
+
+// ============================================================================
+// MANAGER
+// ============================================================================
+
+// NewManager creates a session manager backed by the given PTY factory
+func NewManager(ptyFactory interfaces.PTYBridgeFactory) *Manager {
+	return &Manager{
+		ptyFactory: ptyFactory,
+		sessions:   make(map[string]*Session),
+	}
+}
+
+// Create starts a new session; an empty name selects a generated one
+func (m *Manager) Create(appCtx context.Context, name string, options Options) (*Session, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	options, err := options.withProfile()
+	if err != nil {
+		return nil, err
+	}
+	if options, err = options.withDirectory(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if name == "" {
+		prefix := "session"
+		if options.Profile != "" && validateName(options.Profile) == nil {
+			prefix = options.Profile
+		}
+		for {
+			m.sequence++
+			name = fmt.Sprintf("%s-%d", prefix, m.sequence)
+			if m.lookupLocked(name) == nil {
+				break
+			}
+		}
+	}
+	if err := validateName(name); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	if m.lookupLocked(name) != nil {
+		m.mu.Unlock()
+		return nil, ErrNameInUse
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(appCtx)
+	bridge, err := m.ptyFactory.NewPTYBridge(ctx, options.Launch)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create PTY bridge: %w", err)
+	}
+
+	s := &Session{
+		id:         generateID(),
+		name:       name,
+		createdAt:  time.Now(),
+		options:    options,
+		bridge:     bridge,
+		manager:    m,
+		clients:    make(map[string]*Client),
+		scrollback: newHistory(cfg.Terminal.ScrollbackBytes, options.History),
+		done:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+
+		resumeToken:    generateToken(),
+		credit:         make(chan struct{}, 1),
+		broadcastQueue: make(chan []byte, cfg.WebSocket.MessageChannelBuffer),
+	}
+
+	m.mu.Lock()
+	if m.lookupLocked(name) != nil {
+		m.mu.Unlock()
+		cancel()
+		bridge.Close()
+		return nil, ErrNameInUse
+	}
+	m.sessions[s.id] = s
+	m.mu.Unlock()
+
+	s.start(bridge)
+	go s.pumpBroadcasts()
+
+	logger.SessionLogger.Info("Session created", logger.String("session", name), logger.String("id", s.id))
+	return s, nil
+}
+
+// Attach returns the named session, creating it with options when it does
+// not exist
+func (m *Manager) Attach(appCtx context.Context, name string, options Options) (*Session, error) {
+	if name != "" {
+		if s, ok := m.Get(name); ok {
+			return s, nil
+		}
+	}
+
+	s, err := m.Create(appCtx, name, options)
+	if err == ErrNameInUse {
+		if existing, ok := m.Get(name); ok {
+			return existing, nil
+		}
+	}
+	return s, err
+}
+
+// Resume looks up the session a resume token was issued for
+func (m *Manager) Resume(token string) (*Session, bool) {
+	if token == "" {
+		return nil, false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, s := range m.sessions {
+		if subtle.ConstantTimeCompare([]byte(s.resumeToken), []byte(token)) == 1 {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func (m *Manager) lookupLocked(idOrName string) *Session {
+	if s, ok := m.sessions[idOrName]; ok {
+		return s
+	}
+	for _, s := range m.sessions {
+		if s.Name() == idOrName {
+			return s
+		}
+	}
+	return nil
+}
+
+// Get looks up a session by ID or name
+func (m *Manager) Get(idOrName string) (*Session, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := m.lookupLocked(idOrName)
+	return s, s != nil
+}
+
+// List returns a snapshot of all sessions ordered by creation time
+func (m *Manager) List() []Info {
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.RUnlock()
+
+	infos := make([]Info, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, s.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+
+	return infos
+}
+
+// Kill terminates a session by ID or name
+func (m *Manager) Kill(idOrName string) error {
+	s, ok := m.Get(idOrName)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	logger.SessionLogger.Info("Killing session", logger.String("session", s.Name()), logger.String("id", s.id))
+	return s.Close()
+}
+
+// Rename changes the name of a session
+func (m *Manager) Rename(idOrName, newName string) error {
+	if err := validateName(newName); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.lookupLocked(idOrName)
+	if s == nil {
+		return ErrSessionNotFound
+	}
+	if existing := m.lookupLocked(newName); existing != nil && existing != s {
+		return ErrNameInUse
+	}
+
+	s.mu.Lock()
+	oldName := s.name
+	s.name = newName
+	s.mu.Unlock()
+
+	logger.SessionLogger.Info("Session renamed", logger.String("from", oldName), logger.String("to", newName))
+
+	if message := encodeSessionMessage(s.id, newName); message != nil {
+		s.broadcast(Frame{Event: true, Data: message})
+	}
+	if m.store != nil {
+		// A save in progress may still write the record under the old name
+		s.persistMu.Lock()
+		if err := m.store.Remove(oldName); err != nil {
+			logger.SessionLogger.Warn("failed to remove renamed session record", logger.Error(err))
+		}
+		s.persistMu.Unlock()
+		go m.save(s)
+	}
+	return nil
+}
+
+// Signal delivers a signal to the processes of a session by ID or name
+func (m *Manager) Signal(idOrName, signal, target string) error {
+	s, ok := m.Get(idOrName)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return s.Signal(signal, target)
+}
+
+// DisconnectClient detaches a client from whichever session it belongs to
+func (m *Manager) DisconnectClient(clientID string) error {
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.RUnlock()
+
+	for _, s := range sessions {
+		if s.DisconnectClient(clientID, ReasonDisconnected) {
+			logger.SessionLogger.Info("Client disconnected", logger.String("session", s.Name()), logger.String("client", clientID))
+			return nil
+		}
+	}
+	return ErrClientNotFound
+}
+
+// CloseAll terminates every session
+func (m *Manager) CloseAll() {
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.RUnlock()
+
+	// Closing waits for each shell to exit, so hang them all up at once
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			s.Close()
+		}(s)
+	}
+	wg.Wait()
+
+	// Sessions closed earlier may still be waiting for their shells to exit
+	m.closing.Wait()
+}
+
+func (m *Manager) remove(id string) {
+	m.mu.Lock()
+	s := m.sessions[id]
+	delete(m.sessions, id)
+	keepRecord := m.shuttingDown
+	m.mu.Unlock()
+
+	// Sessions that end on their own are not restored; those torn down by a
+	// server shutdown keep their record
+	if s != nil && m.store != nil && !keepRecord {
+		s.persistMu.Lock()
+		defer s.persistMu.Unlock()
+
+		s.removed = true
+		if err := m.store.Remove(s.Name()); err != nil {
+			logger.SessionLogger.Warn("failed to remove session record", logger.String("session", s.Name()), logger.Error(err))
+		}
+	}
+}
+
+// ============================================================================
+// PERSISTENCE
+// ============================================================================
+
+// EnablePersistence records session state in store so sessions survive a
+// server restart
+func (m *Manager) EnablePersistence(store *sessionstore.Store) {
+	m.mu.Lock()
+	m.store = store
+	m.mu.Unlock()
+}
+
+func (m *Manager) save(s *Session) {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	if s.removed {
+		return
+	}
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	if err := m.store.Save(s.record()); err != nil {
+		logger.SessionLogger.Warn("failed to save session", logger.String("session", s.Name()), logger.Error(err))
+	}
+}
+
+// SaveAll writes the current state of every session to the store
+func (m *Manager) SaveAll() {
+	if m.store == nil {
+		return
+	}
+
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.RUnlock()
+
+	for _, s := range sessions {
+		m.save(s)
+	}
+}
+
+// RunPersistence saves session state periodically until ctx is cancelled
+func (m *Manager) RunPersistence(ctx context.Context, interval time.Duration) {
+	if m.store == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.SaveAll()
+		}
+	}
+}
+
+// Restore recreates the sessions saved in the store, starting each in its
+// previous working directory with the saved scrollback as history
+func (m *Manager) Restore(appCtx context.Context) error {
+	if m.store == nil {
+		return nil
+	}
+
+	records, loadErr := m.store.Load()
+	for _, record := range records {
+		options := DefaultOptions()
+		options.Profile = record.Profile
+		options.Args = record.Args
+		if record.ExitAction != "" {
+			options.ExitAction = record.ExitAction
+		}
+		if info, err := os.Stat(record.Cwd); err == nil && info.IsDir() {
+			options.Launch.Dir = record.Cwd
+		}
+		options.Launch.Env = record.Env
+		if len(record.Scrollback) > 0 {
+			options.History = restoredHistory(record.Scrollback, record.SavedAt)
+		}
+
+		if _, err := m.Create(appCtx, record.Name, options); err != nil {
+			logger.SessionLogger.Error("failed to restore session", err, logger.String("session", record.Name))
+			continue
+		}
+		logger.SessionLogger.Info("Session restored", logger.String("session", record.Name), logger.String("cwd", options.Launch.Dir))
+	}
+
+	return loadErr
+}
+
+// Shutdown saves and closes every session, keeping their records so they
+// are restored on the next start
+func (m *Manager) Shutdown() {
+	m.SaveAll()
+
+	m.mu.Lock()
+	m.shuttingDown = true
+	m.mu.Unlock()
+
+	m.CloseAll()
+}
@@ -0,0 +1,159 @@
+package sessionstore
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+const (
+	recordExtension   = ".json"
+	recordPermissions = 0600
+	dirPermissions    = 0700
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// Record is the persisted state of a session
+type Record struct {
+	Name       string    `json:"name"`
+	Profile    string    `json:"profile,omitempty"`
+	Args       []string  `json:"args,omitempty"`
+	ExitAction string    `json:"exit_action,omitempty"`
+	Cwd        string    `json:"cwd,omitempty"`
+	Env        []string  `json:"env,omitempty"`
+	Scrollback []byte    `json:"scrollback,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	SavedAt    time.Time `json:"saved_at"`
+}
+
+// Store keeps one record file per session in a directory
+type Store struct {
+	dir string
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// DefaultDir returns the directory used for session records
+func DefaultDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".portty", cfg.Server.SessionStateDir), nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+recordExtension)
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// New creates a store rooted at dir, creating the directory if needed
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, dirPermissions); err != nil {
+		return nil, fmt.Errorf("failed to create session state directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save atomically writes the record for a session
+func (s *Store) Save(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode session record: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "."+record.Name+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create session record: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session record: %w", err)
+	}
+	if err := tmp.Chmod(recordPermissions); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set session record permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session record: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(record.Name)); err != nil {
+		return fmt.Errorf("failed to save session record: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the record for a session if it exists
+func (s *Store) Remove(name string) error {
+	if err := os.Remove(s.path(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session record: %w", err)
+	}
+	return nil
+}
+
+// Load reads all records ordered by creation time. Unreadable records are
+// skipped and reported in the returned error.
+func (s *Store) Load() ([]Record, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session state directory: %w", err)
+	}
+
+	var records []Record
+	var failed []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, recordExtension) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			failed = append(failed, name)
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil || record.Name == "" {
+			failed = append(failed, name)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	if len(failed) > 0 {
+		return records, fmt.Errorf("skipped unreadable session records: %s", strings.Join(failed, ", "))
+	}
+	return records, nil
+}
@@ -0,0 +1,857 @@
+package tmuxcontrol
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/multiplexer"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+const sendKeysChunkSize = 256
+
+const (
+	listWindowsFormat = "#{window_id}\t#{window_active}\t#{window_layout}\t#{window_name}"
+	listPanesFormat   = "#{pane_id}\t#{window_id}\t#{pane_active}"
+)
+
+var targetPattern = regexp.MustCompile(`^[@%][0-9]+$`)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+type commandCallback func(lines []string, failed bool)
+
+// Bridge drives a tmux control-mode client and exposes its notifications as
+// structured protocol events
+type Bridge struct {
+	cmd          *exec.Cmd
+	stdin        io.WriteCloser
+	stdout       *bufio.Reader
+	events       chan []byte
+	done         chan struct{}
+	sessionName  string
+	ctx          context.Context
+	cancel       context.CancelFunc
+	writeMutex   sync.Mutex
+	pendingMutex sync.Mutex
+	pending      []commandCallback
+	attached     chan struct{}
+	attachOnce   sync.Once
+	exited       chan struct{}
+	closeOnce    sync.Once
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// unescapeOutput decodes the octal escaping tmux applies to %output payloads
+func unescapeOutput(value string) []byte {
+	out := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) {
+			if code, err := strconv.ParseUint(value[i+1:i+4], 8, 8); err == nil {
+				out = append(out, byte(code))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, value[i])
+	}
+	return out
+}
+
+// quoteArgument quotes a value for the tmux command parser
+func quoteArgument(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// ParseLayout parses a tmux window layout description into a tree
+func ParseLayout(layout string) (*protocol.TmuxLayout, error) {
+	comma := strings.IndexByte(layout, ',')
+	if comma < 0 {
+		return nil, fmt.Errorf("invalid layout %q: missing checksum", layout)
+	}
+
+	parser := &layoutParser{input: layout, pos: comma + 1}
+	node, err := parser.parseNode()
+	if err != nil {
+		return nil, fmt.Errorf("invalid layout %q: %w", layout, err)
+	}
+	if parser.pos != len(parser.input) {
+		return nil, fmt.Errorf("invalid layout %q: trailing data at offset %d", layout, parser.pos)
+	}
+
+	return node, nil
+}
+
+type layoutParser struct {
+	input string
+	pos   int
+}
+
+func (lp *layoutParser) parseNumber() (int, error) {
+	start := lp.pos
+	for lp.pos < len(lp.input) && lp.input[lp.pos] >= '0' && lp.input[lp.pos] <= '9' {
+		lp.pos++
+	}
+	if start == lp.pos {
+		return 0, fmt.Errorf("expected number at offset %d", start)
+	}
+	return strconv.Atoi(lp.input[start:lp.pos])
+}
+
+func (lp *layoutParser) expect(c byte) error {
+	if lp.pos >= len(lp.input) || lp.input[lp.pos] != c {
+		return fmt.Errorf("expected %q at offset %d", c, lp.pos)
+	}
+	lp.pos++
+	return nil
+}
+
+func (lp *layoutParser) parseNode() (*protocol.TmuxLayout, error) {
+	node := &protocol.TmuxLayout{}
+	var err error
+
+	if node.Width, err = lp.parseNumber(); err != nil {
+		return nil, err
+	}
+	if err = lp.expect('x'); err != nil {
+		return nil, err
+	}
+	if node.Height, err = lp.parseNumber(); err != nil {
+		return nil, err
+	}
+	if err = lp.expect(','); err != nil {
+		return nil, err
+	}
+	if node.X, err = lp.parseNumber(); err != nil {
+		return nil, err
+	}
+	if err = lp.expect(','); err != nil {
+		return nil, err
+	}
+	if node.Y, err = lp.parseNumber(); err != nil {
+		return nil, err
+	}
+
+	if lp.pos >= len(lp.input) {
+		return nil, fmt.Errorf("unexpected end of layout")
+	}
+
+	switch lp.input[lp.pos] {
+	case ',':
+		lp.pos++
+		paneID, err := lp.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		node.Pane = "%" + strconv.Itoa(paneID)
+		return node, nil
+	case '{', '[':
+		closing := byte('}')
+		node.Split = "horizontal"
+		if lp.input[lp.pos] == '[' {
+			closing = ']'
+			node.Split = "vertical"
+		}
+		lp.pos++
+
+		for {
+			child, err := lp.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, *child)
+
+			if lp.pos >= len(lp.input) {
+				return nil, fmt.Errorf("unterminated split")
+			}
+			if lp.input[lp.pos] == ',' {
+				lp.pos++
+				continue
+			}
+			if err := lp.expect(closing); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", lp.input[lp.pos], lp.pos)
+	}
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// New starts a tmux control-mode client attached to the configured session
+func New(parentCtx context.Context) (*Bridge, error) {
+	return NewWithOptions(parentCtx, interfaces.PTYOptions{})
+}
+
+// NewWithOptions starts a tmux control-mode client, creating the session
+// with the given launch options when it does not exist yet
+func NewWithOptions(parentCtx context.Context, options interfaces.PTYOptions) (*Bridge, error) {
+	ctx, cancel := context.WithCancel(parentCtx)
+
+	sessionName := multiplexer.SessionName(options.Profile)
+	logger.PTYBridgeLogger.Info("Starting tmux control-mode client", logger.String("session", sessionName))
+
+	args := []string{"-C", "new-session", "-A", "-s", sessionName}
+	if options.Dir != "" {
+		args = append(args, "-c", options.Dir)
+	}
+	if options.Command != "" {
+		for _, env := range options.Env {
+			args = append(args, "-e", env)
+		}
+		args = append(append(args, "--", options.Command), options.Args...)
+	}
+
+	term, colorTerm := cfg.Terminal.DefaultTerm, cfg.Terminal.DefaultColor
+	if options.Term != "" {
+		term = options.Term
+	}
+	if options.ColorTerm != "" {
+		colorTerm = options.ColorTerm
+	}
+
+	cmd := exec.CommandContext(ctx, "tmux", args...)
+	cmd.Env = append(os.Environ(),
+		"TERM="+term,
+		"COLORTERM="+colorTerm,
+	)
+	cmd.Env = append(cmd.Env, options.Env...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create tmux stdin pipe: %w", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create tmux stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start tmux control mode: %w", err)
+	}
+
+	bridge := &Bridge{
+		cmd:         cmd,
+		stdin:       stdin,
+		stdout:      bufio.NewReader(stdout),
+		events:      make(chan []byte, cfg.WebSocket.MessageChannelBuffer),
+		done:        make(chan struct{}),
+		attached:    make(chan struct{}),
+		exited:      make(chan struct{}),
+		sessionName: sessionName,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	go bridge.readNotifications()
+	go bridge.monitorContext()
+
+	select {
+	case <-bridge.attached:
+	case <-bridge.done:
+		return nil, fmt.Errorf("tmux control client exited before attaching to session %q", sessionName)
+	case <-time.After(cfg.Server.PTYOperationTimeout):
+		bridge.Close()
+		return nil, fmt.Errorf("timed out waiting for tmux control client to attach to session %q", sessionName)
+	}
+
+	rows, cols := cfg.Terminal.DefaultRows, cfg.Terminal.DefaultCols
+	if options.Rows > 0 && options.Cols > 0 {
+		rows, cols = options.Rows, options.Cols
+	}
+	if err := bridge.Resize(rows, cols); err != nil {
+		bridge.Close()
+		return nil, fmt.Errorf("failed to set initial client size: %w", err)
+	}
+
+	return bridge, nil
+}
+
+func (b *Bridge) monitorContext() {
+	<-b.ctx.Done()
+	b.Close()
+}
+
+func (b *Bridge) readNotifications() {
+	defer b.Close()
+	defer close(b.exited)
+	defer b.cmd.Wait()
+
+	var blockLines []string
+	var blockFromClient bool
+	inBlock := false
+
+	for {
+		line, err := b.stdout.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				logger.PTYBridgeLogger.Error("failed to read tmux control output", err)
+			}
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		if inBlock {
+			if strings.HasPrefix(line, "%end ") || strings.HasPrefix(line, "%error ") {
+				inBlock = false
+				if blockFromClient {
+					b.completeCommand(blockLines, strings.HasPrefix(line, "%error "))
+				}
+				continue
+			}
+			blockLines = append(blockLines, line)
+			continue
+		}
+
+		if strings.HasPrefix(line, "%begin ") {
+			fields := strings.Fields(line)
+			inBlock = true
+			blockLines = nil
+			blockFromClient = len(fields) >= 4 && fields[3] == "1"
+			continue
+		}
+
+		b.handleNotification(line)
+	}
+}
+
+func (b *Bridge) handleNotification(line string) {
+	name, args, _ := strings.Cut(line, " ")
+	event := protocol.TmuxEvent{Type: protocol.TypeTmux}
+
+	switch name {
+	case "%output":
+		pane, value, _ := strings.Cut(args, " ")
+		event.Event = protocol.TmuxEventOutput
+		event.Pane = pane
+		event.Data = unescapeOutput(value)
+	case "%window-add", "%unlinked-window-add":
+		event.Event = protocol.TmuxEventWindowAdd
+		event.Window = args
+	case "%window-close", "%unlinked-window-close":
+		event.Event = protocol.TmuxEventWindowClose
+		event.Window = args
+	case "%window-renamed", "%unlinked-window-renamed":
+		window, windowName, _ := strings.Cut(args, " ")
+		event.Event = protocol.TmuxEventWindowRenamed
+		event.Window = window
+		event.Name = windowName
+	case "%window-pane-changed":
+		window, pane, _ := strings.Cut(args, " ")
+		event.Event = protocol.TmuxEventWindowPaneChanged
+		event.Window = window
+		event.Pane = pane
+	case "%layout-change":
+		fields := strings.Fields(args)
+		if len(fields) < 2 {
+			return
+		}
+		layout, err := ParseLayout(fields[1])
+		if err != nil {
+			logger.PTYBridgeLogger.Warn("failed to parse tmux layout", logger.Error(err))
+			return
+		}
+		event.Event = protocol.TmuxEventLayoutChange
+		event.Window = fields[0]
+		event.Layout = layout
+	case "%session-changed":
+		b.attachOnce.Do(func() { close(b.attached) })
+		session, sessionName, _ := strings.Cut(args, " ")
+		event.Event = protocol.TmuxEventSessionChanged
+		event.Session = session
+		event.Name = sessionName
+	case "%session-renamed":
+		event.Event = protocol.TmuxEventSessionRenamed
+		event.Name = args
+	case "%session-window-changed":
+		session, window, _ := strings.Cut(args, " ")
+		event.Event = protocol.TmuxEventSessionWindowChanged
+		event.Session = session
+		event.Window = window
+	case "%sessions-changed":
+		event.Event = protocol.TmuxEventSessionsChanged
+	case "%pane-mode-changed":
+		event.Event = protocol.TmuxEventPaneModeChanged
+		event.Pane = args
+	case "%exit":
+		event.Event = protocol.TmuxEventExit
+		event.Message = args
+	default:
+		return
+	}
+
+	b.emit(event)
+}
+
+func (b *Bridge) emit(event protocol.TmuxEvent) {
+	data, err := protocol.Encode(event)
+	if err != nil {
+		logger.PTYBridgeLogger.Error("failed to encode tmux event", err, logger.String("event", event.Event))
+		return
+	}
+
+	select {
+	case b.events <- data:
+	case <-b.done:
+	}
+}
+
+func (b *Bridge) emitError(message string) {
+	b.emit(protocol.TmuxEvent{
+		Type:    protocol.TypeTmux,
+		Event:   protocol.TmuxEventError,
+		Message: message,
+	})
+}
+
+func (b *Bridge) completeCommand(lines []string, failed bool) {
+	b.pendingMutex.Lock()
+	if len(b.pending) == 0 {
+		b.pendingMutex.Unlock()
+		return
+	}
+	callback := b.pending[0]
+	b.pending = b.pending[1:]
+	b.pendingMutex.Unlock()
+
+	if callback != nil {
+		callback(lines, failed)
+	}
+}
+
+// command sends a tmux command and registers a callback for its response block
+func (b *Bridge) command(line string, callback commandCallback) error {
+	b.writeMutex.Lock()
+	defer b.writeMutex.Unlock()
+
+	select {
+	case <-b.done:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	if callback == nil {
+		callback = func(lines []string, failed bool) {
+			if failed {
+				b.emitError(strings.Join(lines, "\n"))
+			}
+		}
+	}
+
+	b.pendingMutex.Lock()
+	b.pending = append(b.pending, callback)
+	b.pendingMutex.Unlock()
+
+	if _, err := io.WriteString(b.stdin, line+"\n"); err != nil {
+		// tmux won't answer, so the callback must not take the next reply.
+		// It is still last, since callbacks are only added under writeMutex.
+		b.pendingMutex.Lock()
+		if len(b.pending) > 0 {
+			b.pending = b.pending[:len(b.pending)-1]
+		}
+		b.pendingMutex.Unlock()
+		return fmt.Errorf("failed to send tmux command: %w", err)
+	}
+	return nil
+}
+
+// State describes the current windows, layouts and pane contents as the
+// events a newly attached client needs to catch up. It waits for tmux to
+// answer, so it must not be called from the notification reader.
+func (b *Bridge) State() [][]byte {
+	// outstanding counts the replies still to come, plus one for State
+	// itself until every top-level command has been issued
+	var (
+		mutex       sync.Mutex
+		state       [][]byte
+		outstanding = 1
+	)
+	complete := make(chan struct{})
+	release := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		outstanding--
+		if outstanding == 0 {
+			close(complete)
+		}
+	}
+
+	add := func(event protocol.TmuxEvent) {
+		data, err := protocol.Encode(event)
+		if err != nil {
+			logger.PTYBridgeLogger.Error("failed to encode tmux event", err, logger.String("event", event.Event))
+			return
+		}
+		mutex.Lock()
+		state = append(state, data)
+		mutex.Unlock()
+	}
+	addError := func(lines []string) {
+		add(protocol.TmuxEvent{
+			Type:    protocol.TypeTmux,
+			Event:   protocol.TmuxEventError,
+			Message: strings.Join(lines, "\n"),
+		})
+	}
+	// A command issued from a callback is counted before the callback's own
+	// command is released, so the count only drops to zero after the last
+	// reply
+	request := func(line string, callback commandCallback) {
+		mutex.Lock()
+		outstanding++
+		mutex.Unlock()
+		err := b.command(line, func(lines []string, failed bool) {
+			callback(lines, failed)
+			release()
+		})
+		if err != nil {
+			release()
+		}
+	}
+
+	request("list-windows -F "+quoteArgument(listWindowsFormat), func(lines []string, failed bool) {
+		if failed {
+			addError(lines)
+			return
+		}
+
+		for _, line := range lines {
+			fields := strings.SplitN(line, "\t", 4)
+			if len(fields) != 4 {
+				continue
+			}
+
+			add(protocol.TmuxEvent{
+				Type:   protocol.TypeTmux,
+				Event:  protocol.TmuxEventWindowAdd,
+				Window: fields[0],
+				Name:   fields[3],
+				Active: fields[1] == "1",
+			})
+
+			if layout, err := ParseLayout(fields[2]); err == nil {
+				add(protocol.TmuxEvent{
+					Type:   protocol.TypeTmux,
+					Event:  protocol.TmuxEventLayoutChange,
+					Window: fields[0],
+					Layout: layout,
+				})
+			}
+		}
+	})
+
+	request("list-panes -s -F "+quoteArgument(listPanesFormat), func(lines []string, failed bool) {
+		if failed {
+			addError(lines)
+			return
+		}
+
+		for _, line := range lines {
+			fields := strings.SplitN(line, "\t", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			pane, window := fields[0], fields[1]
+
+			if fields[2] == "1" {
+				add(protocol.TmuxEvent{
+					Type:   protocol.TypeTmux,
+					Event:  protocol.TmuxEventWindowPaneChanged,
+					Window: window,
+					Pane:   pane,
+				})
+			}
+
+			request("capture-pane -p -e -J -t "+pane, func(content []string, failed bool) {
+				for len(content) > 0 && strings.TrimSpace(content[len(content)-1]) == "" {
+					content = content[:len(content)-1]
+				}
+				if failed || len(content) == 0 {
+					return
+				}
+				add(protocol.TmuxEvent{
+					Type:  protocol.TypeTmux,
+					Event: protocol.TmuxEventOutput,
+					Pane:  pane,
+					Data:  []byte(strings.Join(content, "\r\n")),
+				})
+			})
+		}
+	})
+	release()
+
+	select {
+	case <-complete:
+	case <-b.done:
+	case <-time.After(cfg.Server.PTYOperationTimeout):
+		logger.PTYBridgeLogger.Warn("timed out reading tmux state", logger.String("session", b.sessionName))
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([][]byte(nil), state...)
+}
+
+func (b *Bridge) sendKeys(target string, data []byte) error {
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > sendKeysChunkSize {
+			chunk = chunk[:sendKeysChunkSize]
+		}
+		data = data[len(chunk):]
+
+		hexKeys := make([]string, len(chunk))
+		for i, c := range chunk {
+			hexKeys[i] = hex.EncodeToString([]byte{c})
+		}
+
+		line := "send-keys -H"
+		if target != "" {
+			line += " -t " + target
+		}
+		line += " " + strings.Join(hexKeys, " ")
+
+		if err := b.command(line, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) executeCommand(msg protocol.TmuxCommandMessage) error {
+	if msg.Target != "" && !targetPattern.MatchString(msg.Target) {
+		return fmt.Errorf("invalid tmux target %q", msg.Target)
+	}
+
+	target := ""
+	if msg.Target != "" {
+		target = " -t " + msg.Target
+	}
+
+	var line string
+	switch msg.Command {
+	case "new-window":
+		line = "new-window"
+	case "select-window", "kill-window", "select-pane", "kill-pane":
+		line = msg.Command + target
+	case "rename-window":
+		line = "rename-window" + target + " " + quoteArgument(msg.Name)
+	case "split-window":
+		switch msg.Direction {
+		case "", "horizontal":
+			line = "split-window -h" + target
+		case "vertical":
+			line = "split-window -v" + target
+		default:
+			return fmt.Errorf("invalid split direction %q", msg.Direction)
+		}
+	case "zoom-pane":
+		line = "resize-pane -Z" + target
+	default:
+		return fmt.Errorf("unsupported tmux command %q", msg.Command)
+	}
+
+	return b.command(line, nil)
+}
+
+func (b *Bridge) Read(ctx context.Context, buf []byte) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-b.done:
+		return 0, io.EOF
+	}
+}
+
+func (b *Bridge) Write(ctx context.Context, data []byte) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-b.ctx.Done():
+		return 0, b.ctx.Err()
+	default:
+	}
+
+	if err := b.sendKeys("", data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (b *Bridge) ProcessInput(ctx context.Context, data []byte) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.ctx.Done():
+		return b.ctx.Err()
+	default:
+	}
+
+	msgType, ok := protocol.DecodeType(data)
+	if !ok {
+		_, err := b.Write(ctx, data)
+		return err
+	}
+
+	switch msgType {
+	case protocol.TypeResize:
+		var resizeMsg protocol.ResizeMessage
+		if err := json.Unmarshal(data, &resizeMsg); err != nil {
+			logger.PTYBridgeLogger.Error("failed to parse resize message", err)
+			return nil
+		}
+		return b.Resize(resizeMsg.Dimensions.Rows, resizeMsg.Dimensions.Cols)
+	case protocol.TypeKeepalive:
+		return nil
+	case protocol.TypeTmuxInput:
+		var inputMsg protocol.TmuxInputMessage
+		if err := json.Unmarshal(data, &inputMsg); err != nil {
+			logger.PTYBridgeLogger.Error("failed to parse tmux input message", err)
+			return nil
+		}
+		if !targetPattern.MatchString(inputMsg.Pane) {
+			b.emitError(fmt.Sprintf("invalid tmux pane %q", inputMsg.Pane))
+			return nil
+		}
+		return b.sendKeys(inputMsg.Pane, []byte(inputMsg.Data))
+	case protocol.TypeTmuxCommand:
+		var commandMsg protocol.TmuxCommandMessage
+		if err := json.Unmarshal(data, &commandMsg); err != nil {
+			logger.PTYBridgeLogger.Error("failed to parse tmux command message", err)
+			return nil
+		}
+		if err := b.executeCommand(commandMsg); err != nil {
+			logger.PTYBridgeLogger.Warn("rejected tmux command", logger.Error(err))
+			b.emitError(err.Error())
+		}
+		return nil
+	}
+
+	_, err := b.Write(ctx, data)
+	return err
+}
+
+func (b *Bridge) Resize(rows, cols int) error {
+	if rows <= 0 || cols <= 0 {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+	return b.command(fmt.Sprintf("refresh-client -C %d,%d", cols, rows), nil)
+}
+
+func (b *Bridge) Close() error {
+	b.closeOnce.Do(func() {
+		b.writeMutex.Lock()
+		close(b.done)
+		b.stdin.Close()
+		b.writeMutex.Unlock()
+
+		logger.PTYBridgeLogger.Info("Client detached from tmux control mode", logger.String("session", b.sessionName))
+
+		go func() {
+			select {
+			case <-b.exited:
+			case <-time.After(cfg.Server.PTYOperationTimeout):
+				logger.PTYBridgeLogger.Warn("tmux control client did not exit, cancelling")
+			}
+			b.cancel()
+		}()
+	})
+	return nil
+}
+
+// WorkingDirectory returns the current directory of the session's active
+// pane, or "" if tmux can't tell
+func (b *Bridge) WorkingDirectory() string {
+	ctx, cancel := context.WithTimeout(b.ctx, cfg.Server.PTYOperationTimeout)
+	defer cancel()
+	dir, err := (&multiplexer.TmuxMultiplexer{}).PaneDirectory(ctx, b.sessionName)
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// Done is closed once the tmux control client has exited and been reaped
+func (b *Bridge) Done() <-chan struct{} {
+	return b.exited
+}
+
+// Events returns encoded tmux notifications destined for the client
+func (b *Bridge) Events() <-chan []byte {
+	return b.events
+}
+
+func (b *Bridge) Copy(dst io.Writer) {
+	for {
+		select {
+		case event := <-b.events:
+			if _, err := dst.Write(append(event, '\n')); err != nil {
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// ============================================================================
+// INTERFACE COMPLIANCE CHECKS
+// ============================================================================
+
+var (
+	_ interfaces.PTYBridge            = (*Bridge)(nil)
+	_ interfaces.PTYEventSource       = (*Bridge)(nil)
+	_ interfaces.PTYStateReporter     = (*Bridge)(nil)
+	_ interfaces.PTYDirectoryReporter = (*Bridge)(nil)
+)
+
+// ============================================================================
+// FACTORY FUNCTIONS
+// ============================================================================
+
+type Factory struct{}
+
+func NewFactory() interfaces.PTYBridgeFactory {
+	return &Factory{}
+}
+
+func (f *Factory) NewPTYBridge(ctx context.Context, options interfaces.PTYOptions) (interfaces.PTYBridge, error) {
+	return NewWithOptions(ctx, options)
+}
@@ -0,0 +1,257 @@
+package upload
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PiTZE/PorTTY/internal/config"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+var cfg = config.Default
+
+const (
+	// fileMode is given to uploaded files that don't replace an existing one
+	fileMode fs.FileMode = 0644
+	// maxNameLength is the longest file name most filesystems accept
+	maxNameLength = 255
+	// maxRenames bounds the search for a free name when renaming
+	maxRenames = 1000
+	// tempPattern names the hidden file content is written to until the
+	// upload completes
+	tempPattern = ".portty-upload-*"
+)
+
+var (
+	ErrInvalidName     = errors.New("invalid file name")
+	ErrInvalidConflict = errors.New("invalid conflict handling")
+	ErrTooLarge        = errors.New("file too large")
+	ErrSizeMismatch    = errors.New("upload does not match its announced size")
+	ErrExists          = errors.New("file already exists")
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// File is a file being uploaded into a directory. Its content goes to a
+// hidden temporary file in the same directory, which only takes the file's
+// name once all of it has arrived, so an interrupted upload never leaves
+// half a file behind.
+type File struct {
+	dir      string
+	name     string
+	conflict string
+	size     int64
+	received int64
+	temp     *os.File
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// ValidName reports whether name can be used as the name of an uploaded
+// file: a single path element without control characters
+func ValidName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > maxNameLength {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || r == '/' || r == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// numbered returns name with n added before its extension, as in
+// "report (2).pdf"
+func numbered(name string, n int) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if stem == "" {
+		// Dotfiles such as .bashrc have no extension to keep
+		stem, ext = name, ""
+	}
+	return fmt.Sprintf("%s (%d)%s", stem, n, ext)
+}
+
+// place moves temp to path unless a file already exists there
+func place(temp, path string) error {
+	// Linking fails if path exists, where checking first and renaming would
+	// race with whatever else writes to the directory
+	err := os.Link(temp, path)
+	if err == nil {
+		return os.Remove(temp)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	// Not every filesystem supports hard links
+	if _, err := os.Lstat(path); err == nil {
+		return fs.ErrExist
+	}
+	return os.Rename(temp, path)
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// Create starts uploading a file of size bytes named name into dir. conflict
+// is one of the protocol.Conflict values and defaults to the configured one.
+func Create(dir, name string, size int64, conflict string) (*File, error) {
+	if !ValidName(name) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidName, name)
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("%w: negative size", ErrSizeMismatch)
+	}
+	if cfg.Uploads.MaxSize > 0 && size > cfg.Uploads.MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d", ErrTooLarge, size, cfg.Uploads.MaxSize)
+	}
+
+	if conflict == "" {
+		conflict = cfg.Uploads.Conflict
+	}
+	switch conflict {
+	case protocol.ConflictRename, protocol.ConflictOverwrite:
+	case protocol.ConflictFail:
+		// Fail early rather than after the whole file was sent
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			return nil, fmt.Errorf("%w: %s", ErrExists, name)
+		}
+	default:
+		return nil, fmt.Errorf("%w %q (use %s, %s or %s)", ErrInvalidConflict, conflict,
+			protocol.ConflictRename, protocol.ConflictOverwrite, protocol.ConflictFail)
+	}
+
+	temp, err := os.CreateTemp(dir, tempPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		dir:      dir,
+		name:     name,
+		conflict: conflict,
+		size:     size,
+		temp:     temp,
+	}, nil
+}
+
+// Name returns the name the file was uploaded as
+func (f *File) Name() string {
+	return f.name
+}
+
+// Size returns the announced size of the file
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// Received returns how much of the file has been written
+func (f *File) Received() int64 {
+	return f.received
+}
+
+// Complete reports whether all of the file has been written
+func (f *File) Complete() bool {
+	return f.received == f.size
+}
+
+// Write appends content to the file. Content beyond the announced size is
+// refused.
+func (f *File) Write(data []byte) (int, error) {
+	if f.received+int64(len(data)) > f.size {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrSizeMismatch, f.size)
+	}
+	n, err := f.temp.Write(data)
+	f.received += int64(n)
+	return n, err
+}
+
+// Commit gives the completed file its name, handling an existing file of the
+// same name as requested, and returns its path
+func (f *File) Commit() (string, error) {
+	if !f.Complete() {
+		f.Abort()
+		return "", fmt.Errorf("%w: received %d of %d bytes", ErrSizeMismatch, f.received, f.size)
+	}
+	temp := f.temp.Name()
+	if err := f.temp.Close(); err != nil {
+		os.Remove(temp)
+		return "", err
+	}
+
+	path, err := f.commit(temp)
+	if err != nil {
+		os.Remove(temp)
+		return "", err
+	}
+	return path, nil
+}
+
+func (f *File) commit(temp string) (string, error) {
+	path := filepath.Join(f.dir, f.name)
+
+	switch f.conflict {
+	case protocol.ConflictOverwrite:
+		// A replaced file keeps its permissions
+		mode := fileMode
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			mode = info.Mode().Perm()
+		}
+		if err := os.Chmod(temp, mode); err != nil {
+			return "", err
+		}
+		return path, os.Rename(temp, path)
+
+	case protocol.ConflictFail:
+		if err := os.Chmod(temp, fileMode); err != nil {
+			return "", err
+		}
+		if err := place(temp, path); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return "", fmt.Errorf("%w: %s", ErrExists, f.name)
+			}
+			return "", err
+		}
+		return path, nil
+	}
+
+	if err := os.Chmod(temp, fileMode); err != nil {
+		return "", err
+	}
+	for n := 1; n <= maxRenames; n++ {
+		err := place(temp, path)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		path = filepath.Join(f.dir, numbered(f.name, n))
+	}
+	return "", fmt.Errorf("%w: %s and %d numbered copies", ErrExists, f.name, maxRenames)
+}
+
+// Abort abandons the upload and removes what was written
+func (f *File) Abort() {
+	f.temp.Close()
+	os.Remove(f.temp.Name())
+}
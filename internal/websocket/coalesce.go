@@ -0,0 +1,105 @@
+package websocket
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/metrics"
+	"github.com/PiTZE/PorTTY/internal/session"
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// coalescer batches the output of one connection so a chatty program does not
+// turn every PTY read into its own WebSocket message. Output is held for at
+// most the coalesce delay or until the batch reaches the byte threshold, and
+// a small echo of the client's own input is sent right away.
+type coalescer struct {
+	batch   session.Frame
+	pending bool
+	// owned is set once the batch data is a buffer of our own
+	owned bool
+	timer *time.Timer
+	// input is set when the client sends something, so the output it
+	// causes is not held back
+	input atomic.Bool
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+func newCoalescer() *coalescer {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &coalescer{timer: timer}
+}
+
+// noteInput records that the client has sent input
+func (c *coalescer) noteInput() {
+	c.input.Store(true)
+}
+
+// expired is signalled when the coalesce delay of the current batch is over
+func (c *coalescer) expired() <-chan time.Time {
+	return c.timer.C
+}
+
+// add appends an output frame to the batch and reports whether the batch
+// should be flushed now
+func (c *coalescer) add(frame session.Frame) (flush bool, reason *metrics.Counter) {
+	metrics.Output.Chunks.Inc()
+
+	// Frames of one client are contiguous, so the batch keeps the sequence
+	// of its first frame
+	if !c.pending {
+		c.batch = session.Frame{Data: frame.Data, Sequence: frame.Sequence}
+		c.pending = true
+	} else if !c.owned {
+		// The first chunk may be shared with other clients, so it is
+		// copied rather than appended to
+		data := make([]byte, len(c.batch.Data), cfg.WebSocket.CoalesceBytes+len(frame.Data))
+		copy(data, c.batch.Data)
+		c.batch.Data = append(data, frame.Data...)
+		c.owned = true
+	} else {
+		c.batch.Data = append(c.batch.Data, frame.Data...)
+	}
+
+	switch {
+	case len(c.batch.Data) >= cfg.WebSocket.CoalesceBytes || cfg.WebSocket.CoalesceDelay <= 0:
+		return true, &metrics.Output.FlushSize
+	case c.input.Swap(false) && len(c.batch.Data) <= cfg.WebSocket.EchoFlushBytes:
+		return true, &metrics.Output.FlushEcho
+	}
+
+	if len(c.batch.Data) == len(frame.Data) {
+		c.timer.Reset(cfg.WebSocket.CoalesceDelay)
+	}
+	return false, nil
+}
+
+// take returns the batch and starts a new one
+func (c *coalescer) take() (session.Frame, bool) {
+	if !c.pending {
+		return session.Frame{}, false
+	}
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+
+	batch := c.batch
+	c.batch = session.Frame{}
+	c.pending = false
+	c.owned = false
+	return batch, true
+}
@@ -0,0 +1,216 @@
+package websocket
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/PiTZE/PorTTY/internal/framing"
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+	"github.com/PiTZE/PorTTY/internal/session"
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// codec translates between WebSocket messages and session traffic, either in
+// the framed protocol or, for clients that did not negotiate it, in the
+// legacy format where control messages are sniffed out of the input
+type codec struct {
+	framed bool
+	// sequenced sends output with its sequence once resume is negotiated
+	sequenced bool
+	// text sends output as text messages once text-output is negotiated
+	text bool
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+func newCodec(conn *websocket.Conn) codec {
+	return codec{framed: conn.Subprotocol() == protocol.Subprotocol}
+}
+
+// accepts reports whether a received WebSocket message can carry protocol
+// traffic; the framed protocol only uses binary messages
+func (c codec) accepts(messageType int) bool {
+	if c.framed {
+		return messageType == websocket.BinaryMessage
+	}
+	return messageType == websocket.TextMessage || messageType == websocket.BinaryMessage
+}
+
+// output encodes a frame of session output as a WebSocket message
+func (c codec) output(frame session.Frame) (int, []byte) {
+	if !c.framed {
+		if frame.Event {
+			return websocket.TextMessage, frame.Data
+		}
+		return websocket.BinaryMessage, frame.Data
+	}
+
+	if frame.Event {
+		return websocket.BinaryMessage, protocol.EncodeFrame(protocol.OpControl, frame.Data)
+	}
+	if c.text {
+		return websocket.TextMessage, framing.ValidUTF8(frame.Data)
+	}
+	if c.sequenced {
+		return websocket.BinaryMessage, protocol.EncodeOutput(frame.Sequence, frame.Data)
+	}
+	return websocket.BinaryMessage, protocol.EncodeFrame(protocol.OpData, frame.Data)
+}
+
+// resize extracts the terminal size from a message if it is a resize
+func (c codec) resize(message []byte) (protocol.Dimensions, bool) {
+	if c.framed {
+		opcode, payload, err := protocol.DecodeFrame(message)
+		if err != nil || opcode != protocol.OpResize {
+			return protocol.Dimensions{}, false
+		}
+		dimensions, err := protocol.DecodeResize(payload)
+		return dimensions, err == nil
+	}
+
+	if msgType, ok := protocol.DecodeType(message); ok && msgType == protocol.TypeResize {
+		var resize protocol.ResizeMessage
+		if err := json.Unmarshal(message, &resize); err == nil {
+			return resize.Dimensions, true
+		}
+	}
+	return protocol.Dimensions{}, false
+}
+
+// hello extracts the client's hello from a message if it is one
+func (c codec) hello(message []byte) (*protocol.HelloMessage, bool) {
+	if !c.framed {
+		return nil, false
+	}
+	opcode, payload, err := protocol.DecodeFrame(message)
+	if err != nil || opcode != protocol.OpControl {
+		return nil, false
+	}
+	if msgType, ok := protocol.DecodeType(payload); !ok || msgType != protocol.TypeHello {
+		return nil, false
+	}
+	var hello protocol.HelloMessage
+	if err := json.Unmarshal(payload, &hello); err != nil {
+		return nil, false
+	}
+	return &hello, true
+}
+
+// expedite handles frames that must not wait behind queued input, which may
+// be stuck until the client acknowledges output: pings, pongs and acks. It
+// reports whether the message was one of them. client is nil before
+// attaching and probe is nil when latency is not measured.
+func (c codec) expedite(message []byte, client *session.Client, probe *latencyProbe, replies chan<- []byte) bool {
+	if !c.framed {
+		return false
+	}
+	opcode, payload, err := protocol.DecodeFrame(message)
+	if err != nil {
+		return false
+	}
+
+	switch opcode {
+	case protocol.OpPing:
+		select {
+		case replies <- protocol.EncodeFrame(protocol.OpPong, payload):
+		default:
+		}
+		return true
+
+	case protocol.OpPong:
+		if probe != nil {
+			probe.pong(payload, client, replies)
+		}
+		return true
+
+	case protocol.OpAck:
+		n, err := protocol.DecodeAck(payload)
+		if err != nil {
+			logger.WebSocketLogger.Warn("ignoring invalid ack frame", logger.Error(err))
+			return true
+		}
+		if client != nil {
+			client.Acknowledge(int(n))
+		}
+		return true
+	}
+	return false
+}
+
+// dispatch hands a client message to its session. Replies the protocol
+// requires, such as pongs, are queued on replies for the writer. transfers is
+// nil unless the client negotiated file-transfer.
+func (c codec) dispatch(ctx context.Context, sess *session.Session, client *session.Client, message []byte, replies chan<- []byte, transfers *uploads) error {
+	if !c.framed {
+		return sess.ProcessClientInput(ctx, client, message)
+	}
+
+	opcode, payload, err := protocol.DecodeFrame(message)
+	if err != nil {
+		logger.WebSocketLogger.Warn("ignoring invalid frame", logger.Error(err))
+		return nil
+	}
+
+	switch opcode {
+	case protocol.OpData:
+		return sess.ClientInput(ctx, client, payload)
+
+	case protocol.OpResize:
+		dimensions, err := protocol.DecodeResize(payload)
+		if err != nil {
+			logger.WebSocketLogger.Warn("ignoring invalid resize frame", logger.Error(err))
+			return nil
+		}
+		return resizeSession(ctx, sess, dimensions)
+
+	case protocol.OpPing, protocol.OpAck:
+		c.expedite(message, client, nil, replies)
+		return nil
+
+	case protocol.OpPong:
+		return nil
+
+	case protocol.OpUpload:
+		if transfers == nil {
+			logger.WebSocketLogger.Warn("ignoring upload frame without file-transfer")
+			return nil
+		}
+		transfers.write(ctx, payload)
+		return nil
+
+	case protocol.OpControl:
+		msgType, ok := protocol.DecodeType(payload)
+		// The hello only means something as the first message
+		if ok && msgType == protocol.TypeHello {
+			return nil
+		}
+		if ok && transfers != nil && transfers.control(ctx, msgType, payload) {
+			return nil
+		}
+		return sess.ClientControl(ctx, client, payload)
+	}
+
+	// Opcodes from newer protocol revisions are skipped, not fatal
+	logger.WebSocketLogger.Warn("ignoring frame with unknown opcode", logger.Int("opcode", int(opcode)))
+	return nil
+}
+
+// resizeSession resizes the terminal of a session
+func resizeSession(ctx context.Context, sess *session.Session, dimensions protocol.Dimensions) error {
+	resize, err := protocol.Encode(protocol.ResizeMessage{Type: protocol.TypeResize, Dimensions: dimensions})
+	if err != nil {
+		return nil
+	}
+	return sess.ProcessControl(ctx, resize)
+}
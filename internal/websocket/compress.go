@@ -0,0 +1,149 @@
+package websocket
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/metrics"
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// countingConn counts the bytes written to a connection, so the size of
+// compressed frames on the wire can be measured
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+	// response is the first write, which is the upgrader's handshake
+	// response
+	response []byte
+}
+
+// countingHijacker hands the upgrader a counting connection when it hijacks
+// the HTTP connection
+type countingHijacker struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+// deflater writes output frames, compressing those at or above the
+// compression threshold when the client negotiated permessage-deflate
+type deflater struct {
+	conn    *websocket.Conn
+	wire    *countingConn
+	enabled bool
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	if c.response == nil {
+		c.response = append([]byte{}, p...)
+	}
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func (h *countingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	h.conn = &countingConn{Conn: conn}
+	return h.conn, rw, nil
+}
+
+// hasDeflate reports whether headers list permessage-deflate among the
+// WebSocket extensions
+func hasDeflate(headers http.Header) bool {
+	for _, header := range headers["Sec-Websocket-Extensions"] {
+		for _, extension := range strings.Split(header, ",") {
+			if name, _, _ := strings.Cut(extension, ";"); strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// negotiatedDeflate reports whether the upgrader accepted permessage-deflate
+// in the handshake response it wrote to conn
+func negotiatedDeflate(conn *countingConn, r *http.Request) bool {
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(conn.response)), r)
+	if err != nil {
+		return false
+	}
+	return hasDeflate(response.Header)
+}
+
+// upgrade upgrades the request and prepares compression of output frames
+func upgrade(upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request) (*websocket.Conn, *deflater, error) {
+	hijacker := &countingHijacker{ResponseWriter: w}
+	conn, err := upgrader.Upgrade(hijacker, r, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := &deflater{
+		conn:    conn,
+		wire:    hijacker.conn,
+		enabled: negotiatedDeflate(hijacker.conn, r),
+	}
+
+	// Control messages and replies are small, so only output frames chosen
+	// by write are compressed
+	conn.EnableWriteCompression(false)
+	if d.enabled {
+		metrics.Compression.Connections.Inc()
+		if err := conn.SetCompressionLevel(cfg.WebSocket.CompressionLevel); err != nil {
+			logger.WebSocketLogger.Warn("invalid compression level, using the default",
+				logger.Int("compression_level", cfg.WebSocket.CompressionLevel))
+		}
+	}
+	return conn, d, nil
+}
+
+// write sends an output frame
+func (d *deflater) write(messageType int, data []byte) error {
+	if !d.enabled {
+		return d.conn.WriteMessage(messageType, data)
+	}
+	if len(data) < cfg.WebSocket.CompressionThreshold {
+		metrics.Compression.Skipped.Inc()
+		return d.conn.WriteMessage(messageType, data)
+	}
+
+	written := d.wire.written.Load()
+	start := time.Now()
+
+	d.conn.EnableWriteCompression(true)
+	err := d.conn.WriteMessage(messageType, data)
+	d.conn.EnableWriteCompression(false)
+
+	metrics.Compression.Nanoseconds.Add(int64(time.Since(start)))
+	metrics.Compression.Frames.Inc()
+	metrics.Compression.Bytes.Add(int64(len(data)))
+	metrics.Compression.WireBytes.Add(d.wire.written.Load() - written)
+	return err
+}
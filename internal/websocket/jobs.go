@@ -0,0 +1,132 @@
+package websocket
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/jobs"
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+	"github.com/PiTZE/PorTTY/internal/session"
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+const reasonJobFinished = "job finished"
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// serveJob streams a job's output to a read-only viewer: everything
+// retained so far, then live output until the job finishes, then its exit
+// status. Viewers that fall behind are closed with an error so the browser
+// reconnects and replays the output from the start.
+func (h *Handler) serveJob(appCtx context.Context, conn *websocket.Conn, deflater *deflater, codec codec, id string) {
+	defer conn.Close()
+
+	writeClose := func(code int, reason string) {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(cfg.WebSocket.WriteWait))
+	}
+	writeFrame := func(frame session.Frame) error {
+		messageType, data := codec.output(frame)
+		conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+		return deflater.write(messageType, data)
+	}
+
+	job, ok := h.jobs.Get(id)
+	if !ok {
+		writeClose(websocket.ClosePolicyViolation, jobs.ErrJobNotFound.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(appCtx)
+	defer cancel()
+
+	// Input is ignored, but reading is still needed to handle close frames
+	// and answer pings
+	replies := make(chan []byte, cfg.WebSocket.MessageChannelBuffer)
+	go func() {
+		defer cancel()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if !codec.framed || messageType != websocket.BinaryMessage {
+				continue
+			}
+			if hello, ok := codec.hello(message); ok && hello.Version != protocol.Version {
+				writeClose(websocket.ClosePolicyViolation, reasonVersionMismatch(hello.Version))
+				return
+			}
+			codec.expedite(message, nil, nil, replies)
+		}
+	}()
+
+	snapshot, output, stop := job.Watch()
+	defer stop()
+
+	// Viewers are read-only, so no optional features apply
+	if codec.framed {
+		hello, err := protocol.Encode(serverHello([]string{}, nil, false))
+		if err != nil || writeFrame(session.Frame{Event: true, Data: hello}) != nil {
+			return
+		}
+	}
+
+	info := job.Info()
+	message, err := protocol.Encode(protocol.JobMessage{
+		Type:    protocol.TypeJob,
+		ID:      info.ID,
+		Command: info.Command,
+		State:   info.State,
+	})
+	if err != nil || writeFrame(session.Frame{Event: true, Data: message}) != nil {
+		return
+	}
+	if len(snapshot) > 0 && writeFrame(session.Frame{Data: snapshot}) != nil {
+		return
+	}
+
+	logger.WebSocketLogger.Info("Viewing job", logger.String("job", id))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reply := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+			if conn.WriteMessage(websocket.BinaryMessage, reply) != nil {
+				return
+			}
+		case data, ok := <-output:
+			if ok {
+				if writeFrame(session.Frame{Data: data}) != nil {
+					return
+				}
+				continue
+			}
+
+			exited, finished := job.Exited()
+			if !finished {
+				writeClose(websocket.CloseInternalServerErr, session.ReasonOverflow)
+				return
+			}
+			if message, err := protocol.Encode(exited); err == nil {
+				writeFrame(session.Frame{Event: true, Data: message})
+			}
+			writeClose(websocket.CloseNormalClosure, reasonJobFinished)
+			return
+		}
+	}
+}
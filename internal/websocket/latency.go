@@ -0,0 +1,111 @@
+package websocket
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/metrics"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+	"github.com/PiTZE/PorTTY/internal/session"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+// timestampLength is the size of the timestamp carried by latency pings
+const timestampLength = 8
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// latencyProbe measures the round trip time of a connection. The server
+// pings with the time since the connection started and the client echoes it
+// in its pong. The round trip time and its variation are smoothed the way
+// TCP does (RFC 6298), so a single slow pong doesn't dominate.
+type latencyProbe struct {
+	start time.Time
+	// report is set once the client negotiated the latency feature
+	report atomic.Bool
+
+	mu       sync.Mutex
+	measured bool
+	smoothed time.Duration
+	jitter   time.Duration
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+func newLatencyProbe() *latencyProbe {
+	return &latencyProbe{start: time.Now()}
+}
+
+// timestamp returns the current time as a ping payload
+func (p *latencyProbe) timestamp() []byte {
+	payload := make([]byte, timestampLength)
+	binary.BigEndian.PutUint64(payload, uint64(time.Since(p.start)))
+	return payload
+}
+
+// ping returns a ping frame carrying the current time
+func (p *latencyProbe) ping() []byte {
+	return protocol.EncodeFrame(protocol.OpPing, p.timestamp())
+}
+
+// pong records the round trip of a pong answering one of our pings, and
+// queues the result for the client if it asked for it. Pongs answering
+// other pings are ignored.
+func (p *latencyProbe) pong(payload []byte, client *session.Client, replies chan<- []byte) {
+	if len(payload) != timestampLength {
+		return
+	}
+	rtt := time.Since(p.start) - time.Duration(binary.BigEndian.Uint64(payload))
+	if rtt < 0 {
+		return
+	}
+
+	p.mu.Lock()
+	if !p.measured {
+		p.smoothed, p.jitter = rtt, rtt/2
+		p.measured = true
+	} else {
+		deviation := p.smoothed - rtt
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		p.jitter = (3*p.jitter + deviation) / 4
+		p.smoothed = (7*p.smoothed + rtt) / 8
+	}
+	jitter := p.jitter
+	p.mu.Unlock()
+
+	metrics.Latency.Record(rtt)
+	if client != nil {
+		client.SetLatency(rtt, jitter)
+	}
+	if !p.report.Load() {
+		return
+	}
+
+	message, err := protocol.EncodeControl(protocol.LatencyMessage{
+		Type:   protocol.TypeLatency,
+		RTT:    metrics.Milliseconds(rtt),
+		Jitter: metrics.Milliseconds(jitter),
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case replies <- message:
+	default:
+	}
+}
@@ -0,0 +1,133 @@
+package websocket
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+// reasonInputOverflow is the close reason sent to a client that queued more
+// input than the session took
+const reasonInputOverflow = "input queue overflow"
+
+// errInputOverflow is returned by push once the queue holds its limit
+var errInputOverflow = errors.New("input queue overflow")
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// inputQueue carries a connection's messages from its reader to its message
+// processor in order. The processor blocks while the PTY isn't reading input,
+// and the PTY may be stuck writing output that is paused until the client
+// acknowledges some. Queueing the input lets the reader keep handling those
+// acknowledgements instead of waiting for room behind it.
+type inputQueue struct {
+	mu       sync.Mutex
+	messages [][]byte
+	size     int
+	limit    int
+	closed   bool
+	// added is signalled when a message is queued or the queue is closed
+	added chan struct{}
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// newInputQueue creates a queue holding up to limit bytes, or any amount if
+// limit isn't positive
+func newInputQueue(limit int) *inputQueue {
+	return &inputQueue{
+		limit: limit,
+		added: make(chan struct{}, 1),
+	}
+}
+
+// push queues a message without waiting. It returns errInputOverflow if the
+// message would take the queue past its limit; a message always fits into an
+// empty queue.
+func (q *inputQueue) push(message []byte) error {
+	q.mu.Lock()
+	if q.limit > 0 && len(q.messages) > 0 && q.size+len(message) > q.limit {
+		q.mu.Unlock()
+		return errInputOverflow
+	}
+	q.messages = append(q.messages, message)
+	q.size += len(message)
+	q.mu.Unlock()
+	notify(q.added)
+	return nil
+}
+
+// close ends the queue once the messages already in it are taken
+func (q *inputQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	notify(q.added)
+}
+
+// pop takes the oldest message, waiting for one. It reports false once the
+// queue is closed and empty or ctx has ended.
+func (q *inputQueue) pop(ctx context.Context) ([]byte, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.messages) > 0 {
+			message := q.messages[0]
+			q.messages[0] = nil
+			q.messages = q.messages[1:]
+			q.size -= len(message)
+			q.mu.Unlock()
+			return message, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+
+		select {
+		case <-q.added:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// forward passes queued messages on to out in order and closes out once the
+// queue is closed and empty or ctx has ended
+func (q *inputQueue) forward(ctx context.Context, out chan<- []byte) {
+	defer close(out)
+	for {
+		message, ok := q.pop(ctx)
+		if !ok {
+			return
+		}
+		select {
+		case out <- message:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package websocket
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PiTZE/PorTTY/internal/interfaces"
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/metrics"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+	"github.com/PiTZE/PorTTY/internal/session"
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+// TTYDSubprotocol is the subprotocol of ttyd's WebSocket protocol
+const TTYDSubprotocol = "tty"
+
+// Every ttyd message starts with a one-byte command. The client's first
+// message is a JSON object, so its command is the opening brace.
+const (
+	ttydInput  = '0'
+	ttydResize = '1'
+	ttydPause  = '2'
+	ttydResume = '3'
+	ttydJSON   = '{'
+
+	ttydOutput         = '0'
+	ttydSetTitle       = '1'
+	ttydSetPreferences = '2'
+)
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// TTYDHandler serves clients written for ttyd, such as its web page and the
+// scripts and pages embedding it, from PorTTY sessions
+type TTYDHandler struct {
+	sessions *session.Manager
+	upgrader *websocket.Upgrader
+}
+
+// ttydSize is the client's first message, with an auth token ttyd checks
+// and PorTTY ignores, and the payload of its resize messages
+type ttydSize struct {
+	AuthToken string `json:"AuthToken,omitempty"`
+	Columns   int    `json:"columns"`
+	Rows      int    `json:"rows"`
+}
+
+// ttydFlow maps ttyd's pause and resume onto flow control: output is
+// acknowledged as soon as it is written, except while the client has paused
+type ttydFlow struct {
+	mu     sync.Mutex
+	client *session.Client
+	paused bool
+	held   int
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+// IsTTYDRequest reports whether an upgrade request asks for ttyd's protocol
+func IsTTYDRequest(r *http.Request) bool {
+	for _, subprotocol := range websocket.Subprotocols(r) {
+		if subprotocol == TTYDSubprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+// ttydTitle is the window title ttyd sends before the shell reports one
+func ttydTitle(sess *session.Session) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return sess.Name()
+	}
+	return fmt.Sprintf("%s (%s)", sess.Name(), hostname)
+}
+
+// ttydPreferences passes the font settings of the web UI to the client,
+// which applies them as terminal options
+func ttydPreferences() map[string]interface{} {
+	return map[string]interface{}{
+		"fontFamily": cfg.UI.FontFamily,
+		"fontSize":   cfg.UI.FontSize,
+	}
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+// NewTTYDHandler creates a handler for ttyd clients
+func NewTTYDHandler(sessions *session.Manager) *TTYDHandler {
+	return &TTYDHandler{
+		sessions: sessions,
+		upgrader: &websocket.Upgrader{
+			ReadBufferSize:    int(cfg.WebSocket.ReadBufferSize),
+			WriteBufferSize:   int(cfg.WebSocket.WriteBufferSize),
+			CheckOrigin:       func(r *http.Request) bool { return true },
+			Subprotocols:      []string{TTYDSubprotocol},
+			EnableCompression: cfg.WebSocket.Compression,
+		},
+	}
+}
+
+func (f *ttydFlow) attach(client *session.Client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.client = client
+}
+
+func (f *ttydFlow) pause() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paused = true
+}
+
+func (f *ttydFlow) resume() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paused = false
+	if f.client != nil && f.held > 0 {
+		f.client.Acknowledge(f.held)
+	}
+	f.held = 0
+}
+
+// sent acknowledges output written to the client, or holds the
+// acknowledgement back until the client resumes
+func (f *ttydFlow) sent(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.paused {
+		f.held += n
+		return
+	}
+	if f.client != nil {
+		f.client.Acknowledge(n)
+	}
+}
+
+// ttydHandshake waits for the client's first message and returns the
+// terminal size in it. A first message that is something else is returned to
+// be processed as normal input.
+func ttydHandshake(ctx context.Context, messages <-chan []byte) (protocol.Dimensions, []byte) {
+	select {
+	case message, ok := <-messages:
+		if !ok {
+			return protocol.Dimensions{}, nil
+		}
+		if message[0] != ttydJSON {
+			return protocol.Dimensions{}, message
+		}
+		var size ttydSize
+		if err := json.Unmarshal(message, &size); err != nil {
+			logger.WebSocketLogger.Warn("ignoring invalid ttyd handshake", logger.Error(err))
+			return protocol.Dimensions{}, nil
+		}
+		dimensions := protocol.Dimensions{Cols: size.Columns, Rows: size.Rows}
+		if !validDimensions(dimensions) {
+			return protocol.Dimensions{}, nil
+		}
+		return dimensions, nil
+	case <-time.After(cfg.WebSocket.InitialSizeTimeout):
+		logger.WebSocketLogger.Info("ttyd client did not report its terminal size, using defaults")
+	case <-ctx.Done():
+	}
+	return protocol.Dimensions{}, nil
+}
+
+// dispatch hands a ttyd client message to its session
+func (h *TTYDHandler) dispatch(ctx context.Context, sess *session.Session, client *session.Client, message []byte) error {
+	switch message[0] {
+	case ttydInput:
+		return sess.ClientInput(ctx, client, message[1:])
+
+	case ttydResize:
+		var size ttydSize
+		if err := json.Unmarshal(message[1:], &size); err != nil {
+			logger.WebSocketLogger.Warn("ignoring invalid ttyd resize", logger.Error(err))
+			return nil
+		}
+		dimensions := protocol.Dimensions{Cols: size.Columns, Rows: size.Rows}
+		if !validDimensions(dimensions) {
+			return nil
+		}
+		return resizeSession(ctx, sess, dimensions)
+
+	case ttydJSON:
+		// The handshake only means something as the first message
+		return nil
+	}
+
+	logger.WebSocketLogger.Warn("ignoring ttyd message with unknown command", logger.Int("command", int(message[0])))
+	return nil
+}
+
+func (h *TTYDHandler) HandleWS(appCtx context.Context, w http.ResponseWriter, r *http.Request) {
+	conn, deflater, err := upgrade(h.upgrader, w, r)
+	if err != nil {
+		logger.WebSocketLogger.Error("failed to upgrade ttyd connection to WebSocket", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(appCtx)
+	defer cancel()
+
+	messageChan := make(chan []byte, cfg.WebSocket.MessageChannelBuffer)
+	coalescer := newCoalescer()
+	flow := &ttydFlow{}
+
+	conn.SetReadLimit(cfg.WebSocket.MaxMessageSize)
+	// Browsers answer WebSocket pings with the same payload, so the pings
+	// that keep the connection alive also measure its round trip time
+	probe := newLatencyProbe()
+	var attached atomic.Pointer[session.Client]
+	conn.SetPongHandler(func(payload string) error {
+		conn.SetReadDeadline(time.Now().Add(cfg.WebSocket.PongWait))
+		probe.pong([]byte(payload), attached.Load(), nil)
+		return nil
+	})
+
+	queue := newInputQueue(cfg.WebSocket.MaxQueuedInput)
+	go queue.forward(ctx, messageChan)
+	go func() {
+		defer cancel()
+		defer queue.close()
+
+		for {
+			conn.SetReadDeadline(time.Now().Add(cfg.WebSocket.PongWait))
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					logger.WebSocketLogger.Error("unexpected ttyd read error", err)
+				}
+				return
+			}
+			if len(message) == 0 {
+				continue
+			}
+
+			// Pause and resume must not wait behind input, which may be
+			// stuck until the client resumes
+			switch message[0] {
+			case ttydPause:
+				flow.pause()
+				continue
+			case ttydResume:
+				flow.resume()
+				continue
+			}
+
+			if err := queue.push(message); err != nil {
+				logger.WebSocketLogger.Warn("closing ttyd client that overflowed its input queue",
+					logger.String("remote", r.RemoteAddr))
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reasonInputOverflow),
+					time.Now().Add(cfg.WebSocket.WriteWait))
+				return
+			}
+		}
+	}()
+
+	size, pending := ttydHandshake(ctx, messageChan)
+	if ctx.Err() != nil {
+		return
+	}
+
+	query := r.URL.Query()
+	options := sessionOptions(query)
+	options.Launch.Rows, options.Launch.Cols = size.Rows, size.Cols
+
+	sess, err := h.sessions.Attach(appCtx, query.Get("session"), options)
+	if err != nil {
+		logger.WebSocketLogger.Error("failed to attach ttyd client to session", err)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()),
+			time.Now().Add(cfg.WebSocket.WriteWait))
+		return
+	}
+
+	client, err := sess.AddClient(r.RemoteAddr)
+	if err != nil {
+		logger.WebSocketLogger.Error("failed to add ttyd client to session", err, logger.String("session", sess.Name()))
+		return
+	}
+	defer sess.RemoveClient(client.ID())
+	if cfg.WebSocket.FlowHighWater > 0 {
+		client.EnableFlowControl()
+	}
+	flow.attach(client)
+	attached.Store(client)
+
+	logger.WebSocketLogger.Info("ttyd client attached",
+		logger.String("session", sess.Name()), logger.String("remote", r.RemoteAddr))
+
+	write := func(command byte, data []byte) error {
+		message := make([]byte, 1+len(data))
+		message[0] = command
+		copy(message[1:], data)
+		conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+		return deflater.write(websocket.BinaryMessage, message)
+	}
+	writeOutput := func(data []byte) error {
+		if err := write(ttydOutput, data); err != nil {
+			return err
+		}
+		metrics.Output.Frames.Inc()
+		metrics.Output.Bytes.Add(int64(len(data)))
+		flow.sent(len(data))
+		return nil
+	}
+	flush := func(reason *metrics.Counter) error {
+		batch, ok := coalescer.take()
+		if !ok {
+			return nil
+		}
+		reason.Inc()
+		return writeOutput(batch.Data)
+	}
+
+	preferences, _ := json.Marshal(ttydPreferences())
+	if write(ttydSetTitle, []byte(ttydTitle(sess))) != nil || write(ttydSetPreferences, preferences) != nil {
+		return
+	}
+
+	go func() {
+		defer cancel()
+
+		if pending != nil {
+			coalescer.noteInput()
+			h.dispatch(ctx, sess, client, pending)
+		}
+		for message := range messageChan {
+			coalescer.noteInput()
+			if err := h.dispatch(ctx, sess, client, message); err != nil {
+				logger.WebSocketLogger.Error("failed to process ttyd input", err)
+				return
+			}
+		}
+	}()
+
+	pingPeriod := cfg.WebSocket.PingPeriod
+	if cfg.WebSocket.LatencyInterval > 0 {
+		pingPeriod = cfg.WebSocket.LatencyInterval
+	}
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			// ttyd clients only answer pings, so the server keeps the
+			// connection alive
+			if err := conn.WriteControl(websocket.PingMessage, probe.timestamp(), time.Now().Add(cfg.WebSocket.WriteWait)); err != nil {
+				return
+			}
+		case <-client.Done():
+			if flush(&metrics.Output.FlushEvent) != nil {
+				return
+			}
+			for {
+				select {
+				case frame := <-client.Frames():
+					if !frame.Event {
+						metrics.Output.Chunks.Inc()
+						metrics.Output.FlushEvent.Inc()
+						if writeOutput(frame.Data) != nil {
+							return
+						}
+					}
+					continue
+				default:
+				}
+				break
+			}
+
+			// ttyd clients reconnect after any other close code
+			switch client.Reason() {
+			case session.ReasonDisconnected, session.ReasonSessionClosed:
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, client.Reason()),
+					time.Now().Add(cfg.WebSocket.WriteWait))
+			}
+			return
+		case <-coalescer.expired():
+			if flush(&metrics.Output.FlushDelay) != nil {
+				return
+			}
+		case frame := <-client.Frames():
+			if !frame.Event {
+				if now, reason := coalescer.add(frame); now && flush(reason) != nil {
+					return
+				}
+				continue
+			}
+
+			// The foreground process becomes the window title; ttyd has no
+			// counterpart for the other events
+			var process protocol.ProcessMessage
+			if msgType, ok := protocol.DecodeType(frame.Data); !ok || msgType != protocol.TypeProcess {
+				continue
+			}
+			if json.Unmarshal(frame.Data, &process) != nil || process.Title == "" {
+				continue
+			}
+			if flush(&metrics.Output.FlushEvent) != nil || write(ttydSetTitle, []byte(process.Title)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// ============================================================================
+// INTERFACE COMPLIANCE CHECKS
+// ============================================================================
+
+var (
+	_ interfaces.WebSocketHandler = (*TTYDHandler)(nil)
+)
@@ -0,0 +1,233 @@
+package websocket
+
+// ============================================================================
+// IMPORTS
+// ============================================================================
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/protocol"
+	"github.com/PiTZE/PorTTY/internal/session"
+	"github.com/PiTZE/PorTTY/internal/upload"
+)
+
+// ============================================================================
+// CONSTANTS AND GLOBAL VARIABLES
+// ============================================================================
+
+// maxUploads bounds how many files one connection uploads at a time
+const maxUploads = 8
+
+// progressInterval is how much of a file arrives between progress reports.
+// Clients pace themselves by these reports, so it has to stay well below
+// how far ahead they send.
+const progressInterval = 256 * 1024
+
+// ============================================================================
+// TYPE DEFINITIONS
+// ============================================================================
+
+// uploads tracks the files a connection is uploading into its session's
+// working directory. Only the connection's message processor uses it.
+type uploads struct {
+	sess    *session.Session
+	replies chan<- []byte
+	files   map[uint32]*transfer
+}
+
+type transfer struct {
+	file *upload.File
+	// reported is how much had arrived at the last progress report
+	reported int64
+}
+
+// ============================================================================
+// CORE BUSINESS LOGIC
+// ============================================================================
+
+func newUploads(sess *session.Session, replies chan<- []byte) *uploads {
+	return &uploads{sess: sess, replies: replies, files: make(map[uint32]*transfer)}
+}
+
+// control handles upload control messages and reports whether the message
+// was one
+func (u *uploads) control(ctx context.Context, msgType string, payload []byte) bool {
+	switch msgType {
+	case protocol.TypeUpload:
+		var message protocol.UploadMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			logger.WebSocketLogger.Warn("ignoring malformed upload message", logger.Error(err))
+			return true
+		}
+		u.start(ctx, message)
+		return true
+
+	case protocol.TypeUploadCancel:
+		var message protocol.UploadCancelMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			logger.WebSocketLogger.Warn("ignoring malformed upload cancel message", logger.Error(err))
+			return true
+		}
+		u.cancel(ctx, message.ID)
+		return true
+	}
+	return false
+}
+
+func (u *uploads) start(ctx context.Context, message protocol.UploadMessage) {
+	status := protocol.UploadStatusMessage{
+		Type: protocol.TypeUploadStatus,
+		ID:   message.ID,
+		Size: message.Size,
+	}
+	fail := func(reason string) {
+		status.State, status.Error = protocol.UploadFailed, reason
+		u.report(ctx, status)
+	}
+
+	if _, busy := u.files[message.ID]; busy {
+		fail("upload ID already in use")
+		return
+	}
+	if len(u.files) >= maxUploads {
+		fail("too many uploads at once")
+		return
+	}
+
+	dir, err := u.sess.WorkingDirectory()
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	file, err := upload.Create(dir, message.Name, message.Size, message.Conflict)
+	if err != nil {
+		logger.WebSocketLogger.Warn("refusing upload",
+			logger.String("session", u.sess.Name()),
+			logger.String("name", message.Name),
+			logger.Error(err))
+		fail(err.Error())
+		return
+	}
+
+	u.files[message.ID] = &transfer{file: file}
+	status.State = protocol.UploadStarted
+	status.ChunkSize = protocol.UploadChunkSize(cfg.WebSocket.MaxMessageSize)
+	u.report(ctx, status)
+
+	// An empty file is complete right away
+	if file.Complete() {
+		u.finish(ctx, message.ID)
+	}
+}
+
+// write stores the content of an OpUpload frame. Frames for unknown uploads
+// are dropped, since a cancelled upload may still have some in flight.
+func (u *uploads) write(ctx context.Context, payload []byte) {
+	id, data, err := protocol.DecodeUpload(payload)
+	if err != nil {
+		logger.WebSocketLogger.Warn("ignoring invalid upload frame", logger.Error(err))
+		return
+	}
+	t, ok := u.files[id]
+	if !ok {
+		return
+	}
+
+	if _, err := t.file.Write(data); err != nil {
+		t.file.Abort()
+		delete(u.files, id)
+		u.report(ctx, protocol.UploadStatusMessage{
+			Type:     protocol.TypeUploadStatus,
+			ID:       id,
+			State:    protocol.UploadFailed,
+			Received: t.file.Received(),
+			Size:     t.file.Size(),
+			Error:    err.Error(),
+		})
+		return
+	}
+
+	if t.file.Complete() {
+		u.finish(ctx, id)
+		return
+	}
+	if t.file.Received()-t.reported >= progressInterval {
+		t.reported = t.file.Received()
+		u.report(ctx, protocol.UploadStatusMessage{
+			Type:     protocol.TypeUploadStatus,
+			ID:       id,
+			State:    protocol.UploadProgress,
+			Received: t.reported,
+			Size:     t.file.Size(),
+		})
+	}
+}
+
+// finish gives a completely received file its name
+func (u *uploads) finish(ctx context.Context, id uint32) {
+	t := u.files[id]
+	delete(u.files, id)
+
+	status := protocol.UploadStatusMessage{
+		Type:     protocol.TypeUploadStatus,
+		ID:       id,
+		Received: t.file.Received(),
+		Size:     t.file.Size(),
+	}
+	path, err := t.file.Commit()
+	if err != nil {
+		logger.WebSocketLogger.Warn("failed to save upload",
+			logger.String("session", u.sess.Name()),
+			logger.String("name", t.file.Name()),
+			logger.Error(err))
+		status.State, status.Error = protocol.UploadFailed, err.Error()
+	} else {
+		logger.WebSocketLogger.Info("File uploaded",
+			logger.String("session", u.sess.Name()),
+			logger.String("path", path),
+			logger.Int("size", int(t.file.Size())))
+		status.State, status.Path = protocol.UploadDone, path
+	}
+	u.report(ctx, status)
+}
+
+func (u *uploads) cancel(ctx context.Context, id uint32) {
+	t, ok := u.files[id]
+	if !ok {
+		return
+	}
+	t.file.Abort()
+	delete(u.files, id)
+
+	u.report(ctx, protocol.UploadStatusMessage{
+		Type:     protocol.TypeUploadStatus,
+		ID:       id,
+		State:    protocol.UploadCancelled,
+		Received: t.file.Received(),
+		Size:     t.file.Size(),
+	})
+}
+
+// abort abandons every unfinished upload, once the connection is gone
+func (u *uploads) abort() {
+	for id, t := range u.files {
+		t.file.Abort()
+		delete(u.files, id)
+	}
+}
+
+// report queues a status message for the writer. Unlike pongs these are
+// never dropped, since the client waits for them to send more.
+func (u *uploads) report(ctx context.Context, status protocol.UploadStatusMessage) {
+	message, err := protocol.EncodeControl(status)
+	if err != nil {
+		return
+	}
+	select {
+	case u.replies <- message:
+	case <-ctx.Done():
+	}
+}
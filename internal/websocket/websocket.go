@@ -6,15 +6,24 @@ package websocket
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PiTZE/PorTTY/internal/config"
 	"github.com/PiTZE/PorTTY/internal/interfaces"
+	"github.com/PiTZE/PorTTY/internal/jobs"
 	"github.com/PiTZE/PorTTY/internal/logger"
+	"github.com/PiTZE/PorTTY/internal/metrics"
+	"github.com/PiTZE/PorTTY/internal/protocol"
 	"github.com/PiTZE/PorTTY/internal/ptybridge"
+	"github.com/PiTZE/PorTTY/internal/session"
 	"github.com/gorilla/websocket"
 )
 
@@ -24,52 +33,225 @@ import (
 
 var cfg = config.Default
 
+const (
+	maxTerminalCells  = 1000
+	maxTerminalPixels = 100000
+)
+
+// reasonLegacyClient tells clients that did not negotiate the framed protocol
+// why they were turned away; it is usually a page cached from an older
+// release
+const reasonLegacyClient = "This page is from an older PorTTY release. Reload the page to update it."
+
 // ============================================================================
 // TYPE DEFINITIONS
 // ============================================================================
 
 type Handler struct {
-	ptyFactory interfaces.PTYBridgeFactory
-	upgrader   *websocket.Upgrader
+	sessions *session.Manager
+	jobs     *jobs.Manager
+	upgrader *websocket.Upgrader
+}
+
+// ============================================================================
+// UTILITY FUNCTIONS
+// ============================================================================
+
+func parseDimension(query url.Values, key string, limit int) int {
+	value, err := strconv.Atoi(query.Get(key))
+	if err != nil || value <= 0 || value > limit {
+		return 0
+	}
+	return value
+}
+
+// sessionOptions reads how a new session is started from the query of the
+// upgrade request
+func sessionOptions(query url.Values) session.Options {
+	options := session.DefaultOptions()
+	if profile := query.Get("profile"); profile != "" {
+		options.Profile = profile
+	}
+	options.Args = query["arg"]
+	if dir := query.Get("cwd"); dir != "" {
+		options.Launch.Dir = dir
+	}
+	if exitAction := query.Get("on_exit"); exitAction != "" {
+		options.ExitAction = exitAction
+	}
+	return options
+}
+
+// handshake reads the client's hello and determines its terminal size so new
+// shells start at the right size. The size comes from the hello, the upgrade
+// request (?cols=&rows=&width=&height=) or a resize message sent right after
+// connecting. A first message that is not a hello is returned to be processed
+// as normal input.
+func handshake(ctx context.Context, query url.Values, codec codec, messages <-chan []byte) (*protocol.HelloMessage, protocol.Dimensions, []byte) {
+	size := protocol.Dimensions{
+		Cols:   parseDimension(query, "cols", maxTerminalCells),
+		Rows:   parseDimension(query, "rows", maxTerminalCells),
+		Width:  parseDimension(query, "width", maxTerminalPixels),
+		Height: parseDimension(query, "height", maxTerminalPixels),
+	}
+	sized := size.Cols > 0 && size.Rows > 0
+	// Legacy clients never send a hello, so there is nothing to wait for
+	if sized && !codec.framed {
+		return nil, size, nil
+	}
+
+	select {
+	case message, ok := <-messages:
+		if !ok {
+			return nil, size, nil
+		}
+		if hello, ok := codec.hello(message); ok {
+			if !sized && hello.Dimensions != nil && validDimensions(*hello.Dimensions) {
+				size = *hello.Dimensions
+			}
+			return hello, size, nil
+		}
+		if dimensions, ok := codec.resize(message); ok && !sized {
+			size = dimensions
+		}
+		return nil, size, message
+	case <-time.After(cfg.WebSocket.InitialSizeTimeout):
+		if !sized {
+			logger.WebSocketLogger.Info("Client did not report its terminal size, using defaults")
+		}
+	case <-ctx.Done():
+	}
+	return nil, size, nil
+}
+
+func validDimensions(dimensions protocol.Dimensions) bool {
+	return dimensions.Cols > 0 && dimensions.Cols <= maxTerminalCells &&
+		dimensions.Rows > 0 && dimensions.Rows <= maxTerminalCells &&
+		dimensions.Width >= 0 && dimensions.Width <= maxTerminalPixels &&
+		dimensions.Height >= 0 && dimensions.Height <= maxTerminalPixels
+}
+
+// supportedFeatures lists the optional protocol features this server offers
+// on a connection; compression is only offered when the connection
+// negotiated permessage-deflate
+func supportedFeatures(compressed bool) []string {
+	features := []string{protocol.FeatureClipboard}
+	if compressed {
+		features = append(features, protocol.FeatureCompression)
+	}
+	// tmux control mode sends pane output as events, which the session
+	// doesn't retain for a resume to replay
+	if cfg.WebSocket.ResumeTimeout > 0 && !cfg.Server.TmuxControlMode {
+		features = append(features, protocol.FeatureResume)
+	}
+	if cfg.WebSocket.FlowHighWater > 0 {
+		features = append(features, protocol.FeatureFlowControl)
+	}
+	if cfg.WebSocket.LatencyInterval > 0 {
+		features = append(features, protocol.FeatureLatency)
+	}
+	features = append(features, protocol.FeatureTextOutput)
+	if cfg.Uploads.Enabled {
+		features = append(features, protocol.FeatureFileTransfer)
+	}
+	return features
+}
+
+// withoutFeature returns features with feature removed
+func withoutFeature(features []string, feature string) []string {
+	kept := []string{}
+	for _, f := range features {
+		if f != feature {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// serverHello builds the hello sent to a client once it is attached; sess is
+// nil for job viewers
+func serverHello(features []string, sess *session.Session, resumed bool) protocol.HelloMessage {
+	hello := protocol.HelloMessage{
+		Type:          protocol.TypeHello,
+		Version:       protocol.Version,
+		Features:      features,
+		ServerVersion: cfg.Server.Version,
+		Resumed:       resumed,
+	}
+	if sess != nil {
+		terminal := sess.Terminal()
+		hello.Session = &protocol.HelloSession{ID: sess.ID(), Name: sess.Name()}
+		hello.Terminal = &terminal
+		if protocol.HasFeature(features, protocol.FeatureResume) {
+			hello.ResumeToken = sess.ResumeToken()
+		}
+	}
+	return hello
+}
+
+// reasonVersionMismatch tells a client speaking another protocol version why
+// it was turned away
+func reasonVersionMismatch(version int) string {
+	if version < protocol.Version {
+		return reasonLegacyClient
+	}
+	return fmt.Sprintf("This page speaks protocol version %d, but the server only speaks version %d. Reload the page.",
+		version, protocol.Version)
 }
 
 // ============================================================================
 // CORE BUSINESS LOGIC
 // ============================================================================
 
-// NewHandler creates a new WebSocket handler with PTY factory injection
-func NewHandler(ptyFactory interfaces.PTYBridgeFactory) interfaces.WebSocketHandler {
+// NewHandler creates a new WebSocket handler backed by session and job
+// managers
+func NewHandler(sessions *session.Manager, jobs *jobs.Manager) interfaces.WebSocketHandler {
 	return &Handler{
-		ptyFactory: ptyFactory,
+		sessions: sessions,
+		jobs:     jobs,
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize:  int(cfg.WebSocket.ReadBufferSize),
 			WriteBufferSize: int(cfg.WebSocket.WriteBufferSize),
 			CheckOrigin:     func(r *http.Request) bool { return true },
+			Subprotocols:    []string{protocol.Subprotocol},
+			// Output frames are compressed selectively, see deflater
+			EnableCompression: cfg.WebSocket.Compression,
 		},
 	}
 }
 
 func (h *Handler) HandleWS(appCtx context.Context, w http.ResponseWriter, r *http.Request) {
-	conn, err := h.upgrader.Upgrade(w, r, nil)
+	conn, deflater, err := upgrade(h.upgrader, w, r)
 	if err != nil {
 		logger.WebSocketLogger.Error("failed to upgrade connection to WebSocket", err)
 		return
 	}
 
+	codec := newCodec(conn)
+	if !codec.framed && !cfg.WebSocket.LegacyProtocol {
+		logger.WebSocketLogger.Warn("rejecting client without the framed protocol", logger.String("remote", r.RemoteAddr))
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reasonLegacyClient),
+			time.Now().Add(cfg.WebSocket.WriteWait))
+		conn.Close()
+		return
+	}
+
+	if id := r.URL.Query().Get("job"); id != "" {
+		h.serveJob(appCtx, conn, deflater, codec, id)
+		return
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(3)
 
 	ctx, cancel := context.WithCancel(appCtx)
 	defer cancel()
 
-	ptyBridge, err := h.ptyFactory.NewPTYBridge(ctx)
-	if err != nil {
-		logger.WebSocketLogger.Error("failed to create PTY bridge", err)
-		conn.Close()
-		return
-	}
-
 	messageChan := make(chan []byte, cfg.WebSocket.MessageChannelBuffer)
+	replies := make(chan []byte, cfg.WebSocket.MessageChannelBuffer)
+	coalescer := newCoalescer()
+	probe := newLatencyProbe()
 
 	conn.SetReadLimit(cfg.WebSocket.MaxMessageSize)
 	conn.SetReadDeadline(time.Now().Add(cfg.WebSocket.PongWait))
@@ -78,10 +260,21 @@ func (h *Handler) HandleWS(appCtx context.Context, w http.ResponseWriter, r *htt
 		return nil
 	})
 
+	// Only a client that closes the connection normally is done with the
+	// session; any other disconnect may be followed by a resume
+	var closedNormally atomic.Bool
+
+	// The reader keeps its own copy of the codec, taken before the handshake.
+	// That is all it needs: negotiated features only change how output is
+	// encoded, and codec is written once they are known.
+	readerCodec := codec
+	var attached atomic.Pointer[session.Client]
+	queue := newInputQueue(cfg.WebSocket.MaxQueuedInput)
+	go queue.forward(ctx, messageChan)
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		defer close(messageChan)
+		defer queue.close()
 
 		for {
 			select {
@@ -93,28 +286,140 @@ func (h *Handler) HandleWS(appCtx context.Context, w http.ResponseWriter, r *htt
 
 				messageType, message, err := conn.ReadMessage()
 				if err != nil {
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+						closedNormally.Store(true)
+					} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 						logger.WebSocketLogger.Error("unexpected WebSocket read error", err)
 					}
 					return
 				}
 
-				if messageType == websocket.TextMessage || messageType == websocket.BinaryMessage {
-					select {
-					case messageChan <- message:
-					case <-ctx.Done():
+				if readerCodec.accepts(messageType) {
+					if readerCodec.expedite(message, attached.Load(), probe, replies) {
+						continue
+					}
+					// Queueing rather than dropping input keeps pasted
+					// text intact. A client that keeps sending while the
+					// session takes nothing is cut off rather than let the
+					// queue grow without bound.
+					if err := queue.push(message); err != nil {
+						logger.WebSocketLogger.Warn("closing client that overflowed its input queue",
+							logger.String("remote", r.RemoteAddr))
+						conn.WriteControl(websocket.CloseMessage,
+							websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reasonInputOverflow),
+							time.Now().Add(cfg.WebSocket.WriteWait))
 						return
-					default:
-						logger.WebSocketLogger.Warn("message channel full, dropping message")
 					}
 				}
 			}
 		}
 	}()
 
+	query := r.URL.Query()
+	options := sessionOptions(query)
+
+	hello, size, pending := handshake(ctx, query, codec, messageChan)
+	if ctx.Err() != nil {
+		conn.Close()
+		return
+	}
+	features := []string{}
+	if hello != nil {
+		if hello.Version != protocol.Version {
+			logger.WebSocketLogger.Warn("rejecting client with another protocol version",
+				logger.Int("version", hello.Version), logger.String("remote", r.RemoteAddr))
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reasonVersionMismatch(hello.Version)),
+				time.Now().Add(cfg.WebSocket.WriteWait))
+			conn.Close()
+			return
+		}
+		features = protocol.NegotiateFeatures(hello.Features, supportedFeatures(deflater.enabled))
+	}
+	// Text output carries no sequence, so a client asking for it can't resume
+	if protocol.HasFeature(features, protocol.FeatureTextOutput) {
+		features = withoutFeature(features, protocol.FeatureResume)
+	}
+	codec.sequenced = protocol.HasFeature(features, protocol.FeatureResume)
+	codec.text = protocol.HasFeature(features, protocol.FeatureTextOutput)
+	options.Launch.Rows, options.Launch.Cols = size.Rows, size.Cols
+	options.Launch.Width, options.Launch.Height = size.Width, size.Height
+
+	// A client resuming after a dropped connection returns to the same
+	// session, even if it was renamed, and gets only the output it missed
+	var sess *session.Session
+	if codec.sequenced && hello.Resume != nil {
+		sess, _ = h.sessions.Resume(hello.Resume.Token)
+	}
+	if sess == nil {
+		sess, err = h.sessions.Attach(appCtx, query.Get("session"), options)
+	}
+	if err != nil {
+		logger.WebSocketLogger.Error("failed to attach to session", err)
+		// Rejected options will fail the same way on every retry
+		closeCode := websocket.CloseInternalServerErr
+		if errors.Is(err, session.ErrInvalidOptions) {
+			closeCode = websocket.ClosePolicyViolation
+		}
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(closeCode, err.Error()),
+			time.Now().Add(cfg.WebSocket.WriteWait))
+		conn.Close()
+		return
+	}
+
+	var client *session.Client
+	resumed := false
+	if codec.sequenced && hello.Resume != nil && hello.Resume.Token == sess.ResumeToken() {
+		client, resumed, err = sess.ResumeClient(r.RemoteAddr, hello.Resume.Sequence)
+	} else {
+		client, err = sess.AddClient(r.RemoteAddr)
+	}
+	if err != nil {
+		logger.WebSocketLogger.Error("failed to add client to session", err, logger.String("session", sess.Name()))
+		conn.Close()
+		return
+	}
+	if protocol.HasFeature(features, protocol.FeatureFlowControl) {
+		client.EnableFlowControl()
+	}
+	probe.report.Store(protocol.HasFeature(features, protocol.FeatureLatency))
+	attached.Store(client)
+	defer func() {
+		if codec.sequenced && !closedNormally.Load() {
+			sess.SuspendClient(client.ID())
+			return
+		}
+		sess.RemoveClient(client.ID())
+	}()
+
+	// The hello goes out before anything the session has queued for the
+	// client, since the writer is not running yet
+	if codec.framed {
+		if message, err := protocol.EncodeControl(serverHello(features, sess, resumed)); err == nil {
+			conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+			if err := conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+
+	var transfers *uploads
+	if protocol.HasFeature(features, protocol.FeatureFileTransfer) {
+		transfers = newUploads(sess, replies)
+	}
+
 	go func() {
 		defer wg.Done()
-		defer ptyBridge.Close()
+		defer cancel()
+		if transfers != nil {
+			defer transfers.abort()
+		}
+
+		if pending != nil {
+			codec.dispatch(ctx, sess, client, pending, replies, transfers)
+		}
 
 		for {
 			select {
@@ -126,7 +431,8 @@ func (h *Handler) HandleWS(appCtx context.Context, w http.ResponseWriter, r *htt
 					return
 				}
 
-				if err := ptyBridge.ProcessInput(ctx, message); err != nil {
+				coalescer.noteInput()
+				if err := codec.dispatch(ctx, sess, client, message, replies, transfers); err != nil {
 					if err == io.EOF || err == io.ErrClosedPipe {
 						logger.WebSocketLogger.Error("fatal error processing input", err)
 						return
@@ -140,56 +446,135 @@ func (h *Handler) HandleWS(appCtx context.Context, w http.ResponseWriter, r *htt
 		}
 	}()
 
+	writerDone := make(chan struct{})
 	go func() {
 		defer wg.Done()
 		defer cancel()
 		defer conn.Close()
+		defer close(writerDone)
 
-		buf := make([]byte, cfg.WebSocket.MaxMessageSize)
+		write := func(frame session.Frame) error {
+			messageType, data := codec.output(frame)
+			conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+			if err := deflater.write(messageType, data); err != nil {
+				return err
+			}
+			if !frame.Event {
+				metrics.Output.Frames.Inc()
+				metrics.Output.Bytes.Add(int64(len(frame.Data)))
+				// Replacing invalid bytes changes the length of text
+				// output, and the client acknowledges what it received
+				if difference := len(frame.Data) - len(data); codec.text && difference != 0 {
+					client.Acknowledge(difference)
+				}
+			}
+			return nil
+		}
+
+		// flush writes the coalesced output, if any, and reports whether
+		// the writer should carry on
+		flush := func(reason *metrics.Counter) bool {
+			batch, ok := coalescer.take()
+			if !ok {
+				return true
+			}
+			reason.Inc()
+			if err := write(batch); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					return false
+				}
+
+				select {
+				case <-time.After(cfg.WebSocket.ErrorRetryDelay):
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		}
+
+		// Latency pings start right away so the client can show the
+		// round trip time soon after connecting
+		var probes <-chan time.Time
+		if codec.framed && cfg.WebSocket.LatencyInterval > 0 {
+			ticker := time.NewTicker(cfg.WebSocket.LatencyInterval)
+			defer ticker.Stop()
+			probes = ticker.C
+
+			conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+			if err := conn.WriteMessage(websocket.BinaryMessage, probe.ping()); err != nil {
+				return
+			}
+		}
 
 		for {
 			select {
 			case <-ctx.Done():
 				logger.WebSocketLogger.Info("WebSocket writer shutting down due to context cancellation")
 				return
-			default:
-				n, err := ptyBridge.Read(ctx, buf)
-
-				if err != nil {
-					if err == io.EOF {
-						return
-					}
-
-					if err == io.ErrClosedPipe || err == io.ErrUnexpectedEOF {
-						return
-					}
-
-					if err == context.Canceled || err == context.DeadlineExceeded {
-						logger.WebSocketLogger.Info("PTY read cancelled")
+			case <-probes:
+				conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+				if err := conn.WriteMessage(websocket.BinaryMessage, probe.ping()); err != nil {
+					return
+				}
+			case <-client.Done():
+				// Flush output queued before the detach (such as the exit
+				// status of the shell) and tell the client why it was closed
+				if batch, ok := coalescer.take(); ok {
+					metrics.Output.FlushEvent.Inc()
+					if err := write(batch); err != nil {
 						return
 					}
-
+				}
+				for {
 					select {
-					case <-time.After(cfg.WebSocket.ErrorRetryDelay):
+					case frame := <-client.Frames():
+						if !frame.Event {
+							metrics.Output.Chunks.Inc()
+							metrics.Output.FlushEvent.Inc()
+						}
+						if err := write(frame); err != nil {
+							return
+						}
 						continue
-					case <-ctx.Done():
-						return
+					default:
 					}
+					break
 				}
 
-				if n > 0 {
-					conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
-					if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+				switch client.Reason() {
+				case session.ReasonDisconnected, session.ReasonSessionClosed:
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseNormalClosure, client.Reason()),
+						time.Now().Add(cfg.WebSocket.WriteWait))
+				}
+				return
+			case reply := <-replies:
+				conn.SetWriteDeadline(time.Now().Add(cfg.WebSocket.WriteWait))
+				if err := conn.WriteMessage(websocket.BinaryMessage, reply); err != nil {
+					return
+				}
+			case <-coalescer.expired():
+				if !flush(&metrics.Output.FlushDelay) {
+					return
+				}
+			case frame := <-client.Frames():
+				// Events are written in order with the output around them
+				if frame.Event {
+					if !flush(&metrics.Output.FlushEvent) {
+						return
+					}
+					if err := write(frame); err != nil {
 						if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 							return
 						}
+					}
+					continue
+				}
 
-						select {
-						case <-time.After(cfg.WebSocket.ErrorRetryDelay):
-							continue
-						case <-ctx.Done():
-							return
-						}
+				if now, reason := coalescer.add(frame); now {
+					if !flush(reason) {
+						return
 					}
 				}
 			}
@@ -197,8 +582,13 @@ func (h *Handler) HandleWS(appCtx context.Context, w http.ResponseWriter, r *htt
 	}()
 
 	select {
-	case <-ptyBridge.Done():
-		logger.WebSocketLogger.Info("PTY bridge closed, terminating WebSocket connection")
+	case <-client.Done():
+		logger.WebSocketLogger.Info("Client detached, terminating WebSocket connection",
+			logger.String("session", sess.Name()), logger.String("reason", client.Reason()))
+		select {
+		case <-writerDone:
+		case <-time.After(cfg.WebSocket.WriteWait):
+		}
 	case <-ctx.Done():
 		logger.WebSocketLogger.Info("Context cancelled, terminating WebSocket connection")
 	}
@@ -218,7 +608,6 @@ func (h *Handler) HandleWS(appCtx context.Context, w http.ResponseWriter, r *htt
 	}
 
 	conn.Close()
-	ptyBridge.Close()
 }
 
 // ============================================================================
@@ -240,7 +629,7 @@ func NewFactory() interfaces.WebSocketHandlerFactory {
 }
 
 func (f *Factory) NewWebSocketHandler(ptyFactory interfaces.PTYBridgeFactory) interfaces.WebSocketHandler {
-	return NewHandler(ptyFactory)
+	return NewHandler(session.NewManager(ptyFactory), jobs.NewManager(ptyFactory))
 }
 
 // ============================================================================
@@ -248,13 +637,17 @@ func (f *Factory) NewWebSocketHandler(ptyFactory interfaces.PTYBridgeFactory) in
 // ============================================================================
 
 func HandleWS(appCtx context.Context, w http.ResponseWriter, r *http.Request) {
-	ptyFactory := &defaultPTYFactory{}
-	handler := NewHandler(ptyFactory)
+	handler := NewHandler(defaultSessions, defaultJobs)
 	handler.HandleWS(appCtx, w, r)
 }
 
 type defaultPTYFactory struct{}
 
-func (f *defaultPTYFactory) NewPTYBridge(ctx context.Context) (interfaces.PTYBridge, error) {
-	return ptybridge.New(ctx)
+var (
+	defaultSessions = session.NewManager(&defaultPTYFactory{})
+	defaultJobs     = jobs.NewManager(&defaultPTYFactory{})
+)
+
+func (f *defaultPTYFactory) NewPTYBridge(ctx context.Context, options interfaces.PTYOptions) (interfaces.PTYBridge, error) {
+	return ptybridge.NewWithOptions(ctx, options)
 }